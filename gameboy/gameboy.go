@@ -0,0 +1,159 @@
+// Package gameboy is an embeddable Game Boy emulator without UI nor file I/O.
+package gameboy
+
+import (
+	"io"
+
+	"github.com/cterence/gbgo/internal/console/components/cartridge"
+	"github.com/cterence/gbgo/internal/console/components/ppu"
+	"github.com/cterence/gbgo/internal/console/components/serial"
+	"github.com/cterence/gbgo/internal/console/core"
+)
+
+const (
+	WIDTH  = ppu.WIDTH
+	HEIGHT = ppu.HEIGHT
+	// 154 lines of 456 dots
+	CYCLES_PER_FRAME = core.CYCLES_PER_FRAME
+
+	HEADER_END = core.HEADER_END
+)
+
+type Buttons struct {
+	A, B, Select, Start   bool
+	Right, Left, Up, Down bool
+}
+
+type GameBoy struct {
+	core *core.Core
+
+	coreOptions []core.Option
+}
+
+type Option func(*GameBoy)
+
+func WithBootROM(bootRom []uint8) Option {
+	return func(gb *GameBoy) {
+		gb.coreOptions = append(gb.coreOptions, core.WithBootROM(bootRom))
+	}
+}
+
+// WithSerialWriter writes the bytes sent over the serial port to w
+func WithSerialWriter(w io.Writer) Option {
+	return func(gb *GameBoy) {
+		gb.coreOptions = append(gb.coreOptions, core.WithSerialOptions(serial.WithWriter(w)))
+	}
+}
+
+// WithFastPath ticks the other components once per instruction instead of at every CPU memory access.
+// It is faster, but memory accesses see the state of the machine up to an instruction late.
+func WithFastPath() Option {
+	return func(gb *GameBoy) {
+		gb.coreOptions = append(gb.coreOptions, core.WithFastPath())
+	}
+}
+
+// New powers on a Game Boy with a ROM. Cartridge RAM isn't written to disk, SaveState includes it.
+func New(rom []uint8, options ...Option) (*GameBoy, error) {
+	gb := &GameBoy{}
+
+	for _, o := range options {
+		o(gb)
+	}
+
+	c, err := core.New(rom, "", "", append(gb.coreOptions, core.WithCartridgeOptions(cartridge.WithNoSave()))...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Reset()
+
+	gb.core = c
+
+	return gb, nil
+}
+
+// StepInstruction emulates a single CPU instruction and returns its duration in T-cycles
+func (gb *GameBoy) StepInstruction() int {
+	return gb.core.Step()
+}
+
+// RunCycles emulates whole instructions until at least the given number of T-cycles have elapsed
+func (gb *GameBoy) RunCycles(cycles uint64) {
+	for start := gb.core.Cycles(); gb.core.Cycles()-start < cycles; {
+		gb.core.Step()
+	}
+}
+
+// RunFrame emulates until the PPU completes a frame, or for a frame worth of cycles while the LCD is off
+func (gb *GameBoy) RunFrame() {
+	frames := gb.core.PPU.GetFrameCount()
+
+	for start := gb.core.Cycles(); gb.core.Cycles()-start < CYCLES_PER_FRAME && gb.core.PPU.GetFrameCount() == frames; {
+		gb.core.Step()
+	}
+}
+
+// Cycles returns the number of T-cycles emulated since power on
+func (gb *GameBoy) Cycles() uint64 {
+	return gb.core.Cycles()
+}
+
+// Frame returns the shades, from 0 (white) to 3 (black), of the last completed frame in row-major order
+func (gb *GameBoy) Frame() []uint8 {
+	frame := gb.core.PPU.GetFrame()
+	pixels := make([]uint8, WIDTH*HEIGHT)
+
+	for y := range HEIGHT {
+		for x := range WIDTH {
+			pixels[y*WIDTH+x] = frame[x][y]
+		}
+	}
+
+	return pixels
+}
+
+func (gb *GameBoy) SetButtons(b Buttons) {
+	gb.core.Joypad.UpdateButtons(b.A, b.B, b.Right, b.Left, b.Up, b.Down, b.Select, b.Start)
+}
+
+// ReadMemory reads an address as the CPU would
+func (gb *GameBoy) ReadMemory(addr uint16) uint8 {
+	return gb.core.Bus.Read(addr)
+}
+
+// WriteMemory writes an address as the CPU would, writes to ROM go to the cartridge controller
+func (gb *GameBoy) WriteMemory(addr uint16, value uint8) {
+	gb.core.Bus.Write(addr, value)
+}
+
+// PeekMemory reads an address like a debugger, without side effects nor access restrictions
+func (gb *GameBoy) PeekMemory(addr uint16) uint8 {
+	return gb.core.Bus.Peek(addr)
+}
+
+// PokeMemory writes an address like a debugger, writes to ROM patch it
+func (gb *GameBoy) PokeMemory(addr uint16, value uint8) {
+	gb.core.Bus.Poke(addr, value)
+}
+
+// PeekBank reads an address of ROM or external RAM in the given bank, mapped or not
+func (gb *GameBoy) PeekBank(bank int, addr uint16) uint8 {
+	return gb.core.Bus.PeekBank(bank, addr)
+}
+
+// PokeBank writes an address of ROM or external RAM in the given bank, mapped or not
+func (gb *GameBoy) PokeBank(bank int, addr uint16, value uint8) {
+	gb.core.Bus.PokeBank(bank, addr, value)
+}
+
+// SaveState writes the whole machine, including the cartridge RAM and banks but not the ROM.
+// Console save states use the same format.
+func (gb *GameBoy) SaveState(w io.Writer) error {
+	return gb.core.SaveState(w)
+}
+
+// LoadState restores a state written by SaveState for the same ROM, the Game Boy is left untouched when it fails
+func (gb *GameBoy) LoadState(r io.Reader) error {
+	return gb.core.LoadState(r)
+}
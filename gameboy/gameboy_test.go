@@ -0,0 +1,219 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	INSTANCES = 4
+
+	WRAM_START = 0xC000
+	WRAM_END   = 0xDFFF
+)
+
+// Instances must not share state, run with -race to catch data races between them
+func Test_Concurrent_Instances(t *testing.T) {
+	rom := make([]uint8, 0x8000)
+
+	// ld hl,$C000; loop: inc [hl]; jr loop
+	copy(rom[0x100:], []uint8{0x21, 0x00, 0xC0, 0x34, 0x18, 0xFD})
+
+	results := runConcurrently(t, rom, 60)
+
+	for _, r := range results[1:] {
+		assert.Equal(t, results[0].state, r.state)
+		assert.Equal(t, results[0].wram, r.wram)
+		assert.Equal(t, results[0].frame, r.frame)
+	}
+}
+
+func Test_Concurrent_Blargg_CPU_Instrs(t *testing.T) {
+	rom, err := os.ReadFile("../sub/gb-test-roms/cpu_instrs/cpu_instrs.gb")
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skip("no cpu_instrs rom")
+	}
+
+	require.NoError(t, err)
+
+	results := runConcurrently(t, rom, 600)
+
+	require.NotEmpty(t, results[0].serial)
+
+	for _, r := range results[1:] {
+		assert.Equal(t, results[0].serial, r.serial)
+	}
+}
+
+type result struct {
+	state  []uint8
+	wram   []uint8
+	frame  []uint8
+	serial string
+}
+
+func runConcurrently(t *testing.T, rom []uint8, frames int) []result {
+	t.Helper()
+
+	var wg sync.WaitGroup
+
+	results := make([]result, INSTANCES)
+	errs := make([]error, INSTANCES)
+
+	for i := range INSTANCES {
+		wg.Go(func() {
+			var serial, state bytes.Buffer
+
+			gb, err := New(rom, WithSerialWriter(&serial))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			for range frames {
+				gb.RunFrame()
+			}
+
+			wram := make([]uint8, 0, WRAM_END-WRAM_START+1)
+			for addr := WRAM_START; addr <= WRAM_END; addr++ {
+				wram = append(wram, gb.PeekMemory(uint16(addr)))
+			}
+
+			errs[i] = gb.SaveState(&state)
+			results[i] = result{state: state.Bytes(), wram: wram, frame: gb.Frame(), serial: serial.String()}
+		})
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	return results
+}
+
+func Test_Peek_Poke(t *testing.T) {
+	// MBC1 with 4 ROM banks, each starting with its number
+	rom := make([]uint8, 4*0x4000)
+	rom[0x147] = 0x01
+	rom[0x148] = 0x01
+
+	for bank := range 4 {
+		rom[bank*0x4000+0x10] = uint8(bank)
+	}
+
+	// di; loop: jr loop
+	copy(rom[0x100:], []uint8{0xF3, 0x18, 0xFE})
+
+	gb, err := New(rom)
+	require.NoError(t, err)
+
+	gb.RunFrame()
+
+	assert.Equal(t, uint8(1), gb.PeekMemory(0x4010), "mapped bank")
+	assert.Equal(t, uint8(3), gb.PeekBank(3, 0x4010))
+	assert.Equal(t, uint8(0xFF), gb.PeekBank(4, 0x4010), "missing bank")
+
+	gb.PokeMemory(0x2000, 0x03)
+	assert.Equal(t, uint8(0x03), gb.PeekBank(0, 0x2000), "ROM is patched")
+	assert.Equal(t, uint8(1), gb.PeekMemory(0x4010), "no bank switch")
+
+	gb.PokeBank(2, 0x4010, 0x42)
+	assert.Equal(t, uint8(0x42), gb.PeekBank(2, 0x4010))
+
+	gb.PokeMemory(0xFF04, 0x12)
+	assert.Equal(t, uint8(0x12), gb.PeekMemory(0xFF04), "DIV isn't reset")
+
+	gb.WriteMemory(0xFF04, 0x12)
+	assert.Equal(t, uint8(0), gb.PeekMemory(0xFF04))
+}
+
+func Test_Save_State(t *testing.T) {
+	// MBC1 with 4 ROM banks and a RAM bank, each ROM bank starting with its number
+	rom := make([]uint8, 4*0x4000)
+	rom[0x147] = 0x02
+	rom[0x148] = 0x01
+	rom[0x149] = 0x02
+
+	for bank := range 4 {
+		rom[bank*0x4000+0x10] = uint8(bank)
+	}
+
+	// di; loop: jr loop
+	copy(rom[0x100:], []uint8{0xF3, 0x18, 0xFE})
+
+	gb, err := New(rom)
+	require.NoError(t, err)
+
+	gb.RunFrame()
+
+	gb.WriteMemory(0x0000, 0x0A)
+	gb.WriteMemory(0xA000, 0x42)
+	gb.WriteMemory(0x2000, 0x03)
+
+	var state bytes.Buffer
+
+	require.NoError(t, gb.SaveState(&state))
+
+	gb.WriteMemory(0xA000, 0x00)
+	gb.WriteMemory(0x2000, 0x01)
+	gb.WriteMemory(0x0000, 0x00)
+
+	require.NoError(t, gb.LoadState(bytes.NewReader(state.Bytes())))
+
+	assert.Equal(t, uint8(0x42), gb.ReadMemory(0xA000), "external RAM and its enable flag")
+	assert.Equal(t, uint8(3), gb.ReadMemory(0x4010), "ROM bank")
+
+	gb.WriteMemory(0x2000, 0x02)
+
+	assert.Error(t, gb.LoadState(bytes.NewReader([]uint8("not a state"))))
+	assert.Error(t, gb.LoadState(bytes.NewReader(state.Bytes()[:state.Len()/2])), "truncated state")
+	assert.Equal(t, uint8(2), gb.ReadMemory(0x4010), "failed loads leave the machine untouched")
+}
+
+func Test_New_Invalid_ROM(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []uint8
+	}{
+		{
+			name: "smaller than its header",
+			rom:  make([]uint8, 0x100),
+		},
+		{
+			// The header declares 2 banks
+			name: "larger than its header",
+			rom:  make([]uint8, 3*0x4000),
+		},
+		{
+			name: "unsupported bank count",
+			rom: func() []uint8 {
+				rom := make([]uint8, 2*0x4000)
+				rom[0x148] = 0x09
+
+				return rom
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gb  *GameBoy
+				err error
+			)
+
+			require.NotPanics(t, func() { gb, err = New(tt.rom) })
+			assert.Error(t, err)
+			assert.Nil(t, gb)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package blargg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cterence/gbgo/internal/console"
+	"github.com/cterence/gbgo/internal/console/components/timer"
+	"github.com/cterence/gbgo/internal/screenshot"
+)
+
+const (
+	// cpu_instrs, the slowest test, reports its result after about a minute of emulated time
+	MAX_CYCLES = 120 * timer.CPU_FREQ
+
+	// Memory report: a status, a signature, then a zero terminated text
+	STATUS_ADDR    = 0xA000
+	SIGNATURE_ADDR = 0xA001
+	TEXT_ADDR      = 0xA004
+	TEXT_END       = 0xBFFF
+
+	STATUS_RUNNING = 0x80
+	STATUS_PASSED  = 0x00
+
+	// Screen report: the text console writes ASCII characters to the first tile map
+	SCREEN_MAP_ADDR  = 0x9800
+	SCREEN_MAP_WIDTH = 32
+	SCREEN_MAP_ROWS  = 32
+	SCREEN_COLUMNS   = 20
+)
+
+var signature = []uint8{0xDE, 0xB0, 0x61}
+
+type Result struct {
+	Passed bool
+	Output string
+	// Last frame when the result was read
+	Frame screenshot.Frame
+}
+
+// serialOutput records the serial output and whether a result was printed
+type serialOutput struct {
+	bytes.Buffer
+	done bool
+}
+
+func (s *serialOutput) Write(p []uint8) (int, error) {
+	n, err := s.Buffer.Write(p)
+	s.done = bytes.Contains(s.Bytes(), []uint8("Passed")) || bytes.Contains(s.Bytes(), []uint8("Failed"))
+
+	return n, err
+}
+
+// RunSerial runs a test ROM that reports its result over the serial port
+func RunSerial(romBytes []uint8) (Result, error) {
+	output := &serialOutput{}
+
+	gb, err := console.NewHeadless(romBytes, console.WithSerialWriter(output))
+	if err != nil {
+		return Result{}, err
+	}
+	defer gb.Close()
+
+	if !gb.RunUntil(MAX_CYCLES, func() bool { return output.done }) {
+		return Result{Output: output.String()}, fmt.Errorf("no serial result after %d cycles", MAX_CYCLES)
+	}
+
+	return Result{
+		Passed: bytes.Contains(output.Bytes(), []uint8("Passed")),
+		Output: output.String(),
+		Frame:  gb.Frame(),
+	}, nil
+}
+
+// RunMemory runs a test ROM that reports its status and text in cartridge RAM.
+// The signature is written before the running status, a result is only read once the test has been seen running.
+func RunMemory(romBytes []uint8) (result Result, err error) {
+	gb, err := console.NewHeadless(romBytes)
+	if err != nil {
+		return Result{}, err
+	}
+	defer gb.Close()
+
+	running := false
+
+	done := gb.RunUntil(MAX_CYCLES, func() bool {
+		for i, b := range signature {
+			if gb.Peek(SIGNATURE_ADDR+uint16(i)) != b {
+				return false
+			}
+		}
+
+		status := gb.Peek(STATUS_ADDR)
+		if status == STATUS_RUNNING {
+			running = true
+
+			return false
+		}
+
+		if !running {
+			return false
+		}
+
+		result.Passed = status == STATUS_PASSED
+		result.Output = readText(gb.Peek)
+		result.Frame = gb.Frame()
+
+		return true
+	})
+	if !done {
+		return result, fmt.Errorf("no memory result after %d cycles", MAX_CYCLES)
+	}
+
+	return result, nil
+}
+
+// RunScreen runs a test ROM that only prints its result on screen, the text is read from the tile map once per frame.
+// The result is read one frame after it is printed, so that the frame shows it.
+func RunScreen(romBytes []uint8) (Result, error) {
+	gb, err := console.NewHeadless(romBytes)
+	if err != nil {
+		return Result{}, err
+	}
+	defer gb.Close()
+
+	frames := gb.FrameCount()
+	printed := uint64(0)
+
+	done := gb.RunUntil(MAX_CYCLES, func() bool {
+		if gb.FrameCount() == frames {
+			return false
+		}
+
+		frames = gb.FrameCount()
+
+		if printed > 0 {
+			return frames > printed
+		}
+
+		if text := readScreen(gb.Peek); strings.Contains(text, "Passed") || strings.Contains(text, "Failed") {
+			printed = frames
+		}
+
+		return false
+	})
+
+	output := readScreen(gb.Peek)
+
+	if !done {
+		return Result{Output: output}, fmt.Errorf("no screen result after %d cycles", MAX_CYCLES)
+	}
+
+	return Result{
+		Passed: strings.Contains(output, "Passed"),
+		Output: output,
+		Frame:  gb.Frame(),
+	}, nil
+}
+
+// readScreen reads the text of the tile map, one line per row
+func readScreen(peek func(uint16) uint8) string {
+	var text strings.Builder
+
+	for row := range SCREEN_MAP_ROWS {
+		var line strings.Builder
+
+		for col := range SCREEN_COLUMNS {
+			b := peek(SCREEN_MAP_ADDR + uint16(row*SCREEN_MAP_WIDTH+col))
+			if b < ' ' || b > '~' {
+				b = ' '
+			}
+
+			line.WriteByte(b)
+		}
+
+		text.WriteString(strings.TrimRight(line.String(), " "))
+		text.WriteByte('\n')
+	}
+
+	return strings.TrimRight(text.String(), "\n")
+}
+
+func readText(peek func(uint16) uint8) string {
+	var text strings.Builder
+
+	for addr := uint16(TEXT_ADDR); addr <= TEXT_END; addr++ {
+		b := peek(addr)
+		if b == 0 {
+			break
+		}
+
+		text.WriteByte(b)
+	}
+
+	return text.String()
+}
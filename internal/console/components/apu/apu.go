@@ -1,6 +1,8 @@
 package apu
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 
 	"github.com/cterence/gbgo/internal/lib"
@@ -110,6 +112,8 @@ func (a *APU) Read(addr uint16) uint8 {
 		return value
 	case 0xFF30, 0xFF31, 0xFF32, 0xFF33, 0xFF34, 0xFF35, 0xFF36, 0xFF37, 0xFF38, 0xFF39, 0xFF3A, 0xFF3B, 0xFF3C, 0xFF3D, 0xFF3E, 0xFF3F:
 		return a.WaveRAM[addr-0xFF30]
+	case 0xFF15, 0xFF1F, 0xFF27, 0xFF28, 0xFF29, 0xFF2A, 0xFF2B, 0xFF2C, 0xFF2D, 0xFF2E, 0xFF2F:
+		return 0xFF
 	default:
 		panic(fmt.Errorf("unsupported read on apu: %x", addr))
 	}
@@ -166,7 +170,40 @@ func (a *APU) Write(addr uint16, value uint8) {
 
 	case 0xFF30, 0xFF31, 0xFF32, 0xFF33, 0xFF34, 0xFF35, 0xFF36, 0xFF37, 0xFF38, 0xFF39, 0xFF3A, 0xFF3B, 0xFF3C, 0xFF3D, 0xFF3E, 0xFF3F:
 		a.WaveRAM[addr-0xFF30] = value
+	case 0xFF15, 0xFF1F, 0xFF27, 0xFF28, 0xFF29, 0xFF2A, 0xFF2B, 0xFF2C, 0xFF2D, 0xFF2E, 0xFF2F:
+		// Unmapped, writes are ignored
 	default:
 		panic(fmt.Errorf("unsupported write on apu: %x", addr))
 	}
 }
+
+// Peek reads like Read, APU register reads have no side effects
+func (a *APU) Peek(addr uint16) uint8 {
+	return a.Read(addr)
+}
+
+// Poke writes like Write, unmapped registers ignore the value
+func (a *APU) Poke(addr uint16, value uint8) {
+	a.Write(addr, value)
+}
+
+func (a *APU) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode APU state: %w", err)
+	}
+
+	a.state = st
+
+	return nil
+}
+
+func (a *APU) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(a.state)
+
+	lib.Assert(err == nil, "failed to encode APU state: %v", err)
+}
@@ -0,0 +1,35 @@
+package apu
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Unmapped_Registers(t *testing.T) {
+	addrs := []uint16{0xFF15, 0xFF1F, 0xFF27, 0xFF28, 0xFF29, 0xFF2A, 0xFF2B, 0xFF2C, 0xFF2D, 0xFF2E, 0xFF2F}
+
+	for _, addr := range addrs {
+		t.Run(fmt.Sprintf("%04X", addr), func(t *testing.T) {
+			a := &APU{}
+
+			assert.NotPanics(t, func() { a.Write(addr, 0x12) })
+			assert.NotPanics(t, func() { a.Poke(addr, 0x34) })
+			assert.Equal(t, uint8(0xFF), a.Read(addr))
+			assert.Equal(t, uint8(0xFF), a.Peek(addr))
+			assert.Equal(t, state{}, a.state)
+		})
+	}
+}
+
+func Test_Peek_Poke(t *testing.T) {
+	a := &APU{}
+
+	a.Poke(0xFF12, 0xF3)
+	a.Poke(0xFF30, 0xAB)
+
+	assert.Equal(t, uint8(0xF3), a.Peek(0xFF12))
+	assert.Equal(t, uint8(0xF3), a.Read(0xFF12))
+	assert.Equal(t, uint8(0xAB), a.Peek(0xFF30))
+}
@@ -1,5 +1,14 @@
 package bus
 
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/cterence/gbgo/internal/console/components/cdl"
+	"github.com/cterence/gbgo/internal/lib"
+)
+
 const (
 	ROM_BANK_0_END = 0x3FFF
 	ROM_BANK_1_END = 0x7FFF
@@ -36,21 +45,47 @@ const (
 type RW interface {
 	Read(addr uint16) uint8
 	Write(addr uint16, value uint8)
+	// Peek and Poke access the component like a debugger, without side effects nor access restrictions
+	Peek(addr uint16) uint8
+	Poke(addr uint16, value uint8)
+}
+
+type Cartridge interface {
+	RW
+	// PeekBank and PokeBank access a ROM or external RAM bank whether or not it is mapped
+	PeekBank(bank int, addr uint16) uint8
+	PokeBank(bank int, addr uint16, value uint8)
+}
+
+type DMA interface {
+	RW
+	// Conflict returns the byte the OAM DMA is transferring when it uses the same bus as addr
+	Conflict(addr uint16) (uint8, bool)
+}
+
+type CDL interface {
+	Log(addr uint16, flag cdl.Flag)
 }
 
 type Bus struct {
 	memory    RW
-	cartridge RW
+	cartridge Cartridge
 	cpu       RW
 	timer     RW
 	ppu       RW
 	serial    RW
-	dma       RW
+	dma       DMA
 	joypad    RW
 	apu       RW
+	cdl       CDL
+
+	bootROM []uint8
+
+	state
+}
 
-	bootROM     []uint8
-	hideBootROM uint8
+type state struct {
+	HideBootROM uint8 // 0xFF50
 }
 
 type Option func(*Bus)
@@ -62,7 +97,14 @@ func WithBootROM(bootRom []uint8) Option {
 	}
 }
 
-func (b *Bus) Init(memory RW, cartridge RW, cpu RW, timer RW, ppu RW, serial RW, dma RW, joypad RW, apu RW, options ...Option) {
+// WithCDL logs every CPU and DMA access to ROM and RAM
+func WithCDL(c CDL) Option {
+	return func(b *Bus) {
+		b.cdl = c
+	}
+}
+
+func (b *Bus) Init(memory RW, cartridge Cartridge, cpu RW, timer RW, ppu RW, serial RW, dma DMA, joypad RW, apu RW, options ...Option) {
 	for _, o := range options {
 		o(b)
 	}
@@ -78,7 +120,7 @@ func (b *Bus) Init(memory RW, cartridge RW, cpu RW, timer RW, ppu RW, serial RW,
 	b.apu = apu
 
 	if len(b.bootROM) == 0 {
-		b.hideBootROM = 1
+		b.HideBootROM = 1
 		b.Write(0xFF05, 0x00)
 		b.Write(0xFF06, 0x00)
 		b.Write(0xFF07, 0xF8)
@@ -114,61 +156,168 @@ func (b *Bus) Init(memory RW, cartridge RW, cpu RW, timer RW, ppu RW, serial RW,
 }
 
 func (b *Bus) Read(addr uint16) uint8 {
-	switch {
-	case addr <= 0xFF && b.hideBootROM == 0:
+	b.log(addr, cdl.READ)
+
+	if value, ok := b.dma.Conflict(addr); ok {
+		return value
+	}
+
+	return b.read(addr)
+}
+
+// Fetch reads an instruction byte
+func (b *Bus) Fetch(addr uint16, opcode bool) uint8 {
+	if opcode {
+		b.log(addr, cdl.EXECUTED)
+	} else {
+		b.log(addr, cdl.OPERAND)
+	}
+
+	if value, ok := b.dma.Conflict(addr); ok {
+		return value
+	}
+
+	return b.read(addr)
+}
+
+// ReadDMA reads a byte for the OAM DMA, which owns the bus during the transfer.
+// VRAM is still locked out during mode 3 as the PPU owns the video bus.
+func (b *Bus) ReadDMA(addr uint16) uint8 {
+	b.log(addr, cdl.READ)
+
+	return b.read(addr)
+}
+
+func (b *Bus) read(addr uint16) uint8 {
+	if addr <= 0xFF && b.HideBootROM == 0 {
 		return b.bootROM[addr]
-	case addr <= ROM_BANK_1_END || (addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END):
-		return b.cartridge.Read(addr)
+	}
+
+	if component, componentAddr := b.route(addr); component != nil {
+		return component.Read(componentAddr)
+	}
+
+	return 0xFF
+}
+
+func (b *Bus) Write(addr uint16, value uint8) {
+	b.log(addr, cdl.WRITTEN)
+
+	if addr == 0xFF50 {
+		b.HideBootROM = value
+
+		return
+	}
+
+	if component, componentAddr := b.route(addr); component != nil {
+		component.Write(componentAddr, value)
+	}
+}
+
+// route returns the component mapped at addr with the address it handles, or nil for unmapped addresses
+func (b *Bus) route(addr uint16) (RW, uint16) {
+	switch {
+	case isCartridge(addr):
+		return b.cartridge, addr
 	case (addr >= VRAM_START && addr <= VRAM_END) || (addr >= OAM_START && addr <= OAM_END) || (addr >= 0xFF40 && addr <= 0xFF4B && addr != 0xFF46):
-		return b.ppu.Read(addr)
+		return b.ppu, addr
 	case addr == 0xFF46:
-		return b.dma.Read(addr)
+		return b.dma, addr
 	case addr == 0xFF01 || addr == 0xFF02:
-		return b.serial.Read(addr)
+		return b.serial, addr
 	case addr == DIV || addr == TIMA || addr == TMA || addr == TAC:
-		return b.timer.Read(addr)
+		return b.timer, addr
 	case addr == IFF || addr == IE:
-		return b.cpu.Read(addr)
+		return b.cpu, addr
 	case addr >= WRAM_START && addr <= WRAM_END || addr >= HRAM_START && addr <= HRAM_END:
-		return b.memory.Read(addr)
+		return b.memory, addr
 	case addr >= ECHO_START && addr <= ECHO_END:
-		return b.memory.Read(addr - ECHO_START + WRAM_START)
-	case addr >= UNUSED_START && addr <= UNUSED_END:
-		return 0xFF
+		return b.memory, addr - ECHO_START + WRAM_START
 	case addr == 0xFF00:
-		return b.joypad.Read(addr)
+		return b.joypad, addr
 	case addr >= 0xFF10 && addr <= 0xFF3F:
-		return b.apu.Read(addr)
+		return b.apu, addr
 	default:
-		return 0xFF
+		return nil, 0
 	}
 }
 
-func (b *Bus) Write(addr uint16, value uint8) {
+func (b *Bus) log(addr uint16, flag cdl.Flag) {
+	if b.cdl == nil || (addr <= 0xFF && b.HideBootROM == 0) {
+		return
+	}
+
+	b.cdl.Log(addr, flag)
+}
+
+// Peek reads memory like a debugger: no component side effect is triggered and the PPU lockouts are ignored
+func (b *Bus) Peek(addr uint16) uint8 {
+	if addr <= 0xFF && b.HideBootROM == 0 {
+		return b.bootROM[addr]
+	}
+
+	if component, componentAddr := b.route(addr); component != nil {
+		return component.Peek(componentAddr)
+	}
+
+	return 0xFF
+}
+
+// Poke writes memory like a debugger, writes to ROM patch it instead of switching banks
+func (b *Bus) Poke(addr uint16, value uint8) {
 	switch {
-	case addr <= ROM_BANK_1_END || (addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END):
-		b.cartridge.Write(addr, value)
-	case addr >= VRAM_START && addr <= VRAM_END || (addr >= OAM_START && addr <= OAM_END) || (addr >= 0xFF40 && addr <= 0xFF4B && addr != 0xFF46):
-		b.ppu.Write(addr, value)
-	case addr == 0xFF46:
-		b.dma.Write(addr, value)
-	case addr == 0xFF01 || addr == 0xFF02:
-		b.serial.Write(addr, value)
-	case addr == DIV || addr == TIMA || addr == TMA || addr == TAC:
-		b.timer.Write(addr, value)
-	case addr == IFF || addr == IE:
-		b.cpu.Write(addr, value)
+	case addr <= 0xFF && b.HideBootROM == 0:
+		b.bootROM[addr] = value
 	case addr == 0xFF50:
-		b.hideBootROM = value
-	case addr >= WRAM_START && addr <= WRAM_END || addr >= HRAM_START && addr <= HRAM_END:
-		b.memory.Write(addr, value)
-	case addr >= ECHO_START && addr <= ECHO_END:
-		b.memory.Write(addr-ECHO_START+WRAM_START, value)
-	case addr >= UNUSED_START && addr <= UNUSED_END:
-	case addr == 0xFF00:
-		b.joypad.Write(addr, value)
-	case addr >= 0xFF10 && addr <= 0xFF3F:
-		b.apu.Write(addr, value)
+		b.HideBootROM = value
 	default:
+		if component, componentAddr := b.route(addr); component != nil {
+			component.Poke(componentAddr, value)
+		}
 	}
 }
+
+// PeekBank reads addr in the given ROM or external RAM bank, other addresses are not banked and ignore it
+func (b *Bus) PeekBank(bank int, addr uint16) uint8 {
+	if isCartridge(addr) {
+		return b.cartridge.PeekBank(bank, addr)
+	}
+
+	return b.Peek(addr)
+}
+
+// PokeBank writes addr in the given ROM or external RAM bank, other addresses are not banked and ignore it
+func (b *Bus) PokeBank(bank int, addr uint16, value uint8) {
+	if isCartridge(addr) {
+		b.cartridge.PokeBank(bank, addr, value)
+
+		return
+	}
+
+	b.Poke(addr, value)
+}
+
+func isCartridge(addr uint16) bool {
+	return addr <= ROM_BANK_1_END || (addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END)
+}
+
+func (b *Bus) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode bus state: %w", err)
+	}
+
+	b.state = st
+
+	return nil
+}
+
+func (b *Bus) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(b.state)
+
+	lib.Assert(err == nil, "failed to encode bus state: %v", err)
+}
@@ -1,6 +1,8 @@
 package cartridge
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cterence/gbgo/internal/lib"
 	"github.com/cterence/gbgo/internal/log"
 )
 
@@ -46,13 +49,10 @@ type Cartridge struct {
 	romPath          string
 	stateDir         string
 	romBanks         [][ROM_BANK_SIZE]uint8
-	externalRAM      [][EXTERNAL_RAM_SIZE]uint8
 	externalRAMMutex sync.Mutex
 
 	romBankCount     uint16
 	ramBankCount     uint8
-	currentROMBank   uint8
-	currentRAMBank   uint8
 	externalRAMDirty bool
 
 	mbc     mbc
@@ -62,13 +62,47 @@ type Cartridge struct {
 	rumble  bool
 	sensor  bool
 
-	ramEnabled bool
+	// Emulator
+	noSave bool
+	log    *log.Logger
+
+	state
+}
+
+// The ROM isn't saved, it comes from the ROM file
+type state struct {
+	ExternalRAM    [][EXTERNAL_RAM_SIZE]uint8
+	CurrentROMBank uint8
+	CurrentRAMBank uint8
+	RAMEnabled     bool
 }
 
-func (c *Cartridge) Init(romPath, stateDir string, cartridgeType, romSize, ramSize uint8) error {
+type Option func(*Cartridge)
+
+// WithNoSave never loads nor flushes the external RAM of battery backed cartridges
+func WithNoSave() Option {
+	return func(c *Cartridge) {
+		c.noSave = true
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Cartridge) {
+		c.log = logger
+	}
+}
+
+func (c *Cartridge) Init(romPath, stateDir string, cartridgeType, romSize, ramSize uint8, options ...Option) error {
 	c.romPath = romPath
-	c.currentROMBank = 1
-	c.currentRAMBank = 0
+	c.noSave = false
+	c.log = nil
+
+	for _, o := range options {
+		o(c)
+	}
+
+	c.CurrentROMBank = 1
+	c.CurrentRAMBank = 0
 
 	c.configure(cartridgeType)
 
@@ -93,9 +127,9 @@ func (c *Cartridge) Init(romPath, stateDir string, cartridgeType, romSize, ramSi
 		c.ramBankCount = 8
 	}
 
-	c.externalRAM = make([][EXTERNAL_RAM_SIZE]uint8, c.ramBankCount)
+	c.ExternalRAM = make([][EXTERNAL_RAM_SIZE]uint8, c.ramBankCount)
 
-	if c.battery {
+	if c.battery && !c.noSave {
 		if err := c.loadExternalRam(); err != nil {
 			return fmt.Errorf("failed to load external RAM: %w", err)
 		}
@@ -107,7 +141,7 @@ func (c *Cartridge) Init(romPath, stateDir string, cartridgeType, romSize, ramSi
 			for range t.C {
 				if c.externalRAMDirty {
 					if err := c.flushExternalRam(); err != nil {
-						log.Debug("[cartridge] failed to flush external RAM: %v", err)
+						c.log.Debug("[cartridge] failed to flush external RAM: %v", err)
 					}
 
 					c.externalRAMDirty = false
@@ -116,9 +150,9 @@ func (c *Cartridge) Init(romPath, stateDir string, cartridgeType, romSize, ramSi
 		}()
 	}
 
-	log.Debug("[cartridge] type: %d", cartridgeType)
-	log.Debug("[cartridge] rom bank count: %d", c.romBankCount)
-	log.Debug("[cartridge] ram bank count: %d", c.ramBankCount)
+	c.log.Debug("[cartridge] type: %d", cartridgeType)
+	c.log.Debug("[cartridge] rom bank count: %d", c.romBankCount)
+	c.log.Debug("[cartridge] ram bank count: %d", c.ramBankCount)
 
 	return nil
 }
@@ -131,11 +165,11 @@ func (c *Cartridge) Read(addr uint16) uint8 {
 	case addr >= ROM_BANK_1_START && addr <= ROM_BANK_1_END:
 		bankAddr := addr % ROM_BANK_SIZE
 
-		return c.romBanks[c.currentROMBank][bankAddr]
+		return c.romBanks[c.CurrentROMBank][bankAddr]
 
 	case addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END:
-		if c.ram && c.ramEnabled {
-			return c.externalRAM[c.currentRAMBank][addr-EXTERNAL_RAM_START]
+		if c.hasRAM() && c.RAMEnabled {
+			return c.ExternalRAM[c.CurrentRAMBank][addr-EXTERNAL_RAM_START]
 		}
 
 		return 0xFF
@@ -145,14 +179,19 @@ func (c *Cartridge) Read(addr uint16) uint8 {
 	}
 }
 
+// hasRAM reports whether external RAM is mapped, some headers declare RAM with a RAM size of 0
+func (c *Cartridge) hasRAM() bool {
+	return c.ram && len(c.ExternalRAM) > 0
+}
+
 func (c *Cartridge) Write(addr uint16, value uint8) {
 	switch {
 	// RAM enable
 	case addr <= 0x1FFF:
 		if value&0xF == 0xA {
-			c.ramEnabled = true
+			c.RAMEnabled = true
 		} else {
-			c.ramEnabled = false
+			c.RAMEnabled = false
 		}
 
 		// ROM bank switch
@@ -161,35 +200,165 @@ func (c *Cartridge) Write(addr uint16, value uint8) {
 			value = 1
 		}
 
-		c.currentROMBank = value
+		// Bank bits above the ROM size are ignored
+		c.CurrentROMBank = uint8(uint16(value) & (c.romBankCount - 1))
 
 	// RAM bank switch
 	case addr >= 0x4000 && addr <= 0x5FFF:
-		c.currentRAMBank = value
+		if c.ramBankCount > 0 {
+			c.CurrentRAMBank = value & (c.ramBankCount - 1)
+		}
 
 	case addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END:
-		if c.ram && c.ramEnabled {
+		if c.hasRAM() && c.RAMEnabled {
 			c.externalRAMMutex.Lock()
-			c.externalRAM[c.currentRAMBank][addr-EXTERNAL_RAM_START] = value
+			c.ExternalRAM[c.CurrentRAMBank][addr-EXTERNAL_RAM_START] = value
 			c.externalRAMMutex.Unlock()
 
 			if !c.externalRAMDirty {
 				c.externalRAMDirty = true
 
-				log.Debug("[cartridge] external ram is dirty")
+				c.log.Debug("[cartridge] external ram is dirty")
 			}
 		}
 	}
 }
 
-func (c *Cartridge) Load(byteIdx uint32, value uint8) {
-	bankIndex := byteIdx / ROM_BANK_SIZE
-	bankAddr := byteIdx % ROM_BANK_SIZE
-	c.romBanks[bankIndex][bankAddr] = value
+// Peek reads the mapped banks, external RAM is readable even when disabled
+func (c *Cartridge) Peek(addr uint16) uint8 {
+	return c.PeekBank(c.mappedBank(addr), addr)
+}
+
+// Poke writes the mapped banks, writes to ROM patch it instead of switching banks
+func (c *Cartridge) Poke(addr uint16, value uint8) {
+	c.PokeBank(c.mappedBank(addr), addr, value)
+}
+
+// PeekBank reads addr in a ROM or external RAM bank, 0xFF when the bank doesn't exist
+func (c *Cartridge) PeekBank(bank int, addr uint16) uint8 {
+	b := c.bankByte(bank, addr)
+	if b == nil {
+		return 0xFF
+	}
+
+	return *b
+}
+
+// PokeBank writes addr in a ROM or external RAM bank, nothing is written when the bank doesn't exist
+func (c *Cartridge) PokeBank(bank int, addr uint16, value uint8) {
+	b := c.bankByte(bank, addr)
+	if b == nil {
+		return
+	}
+
+	if addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END {
+		c.externalRAMMutex.Lock()
+		*b = value
+		c.externalRAMMutex.Unlock()
+
+		c.externalRAMDirty = true
+
+		return
+	}
+
+	*b = value
+}
+
+func (c *Cartridge) mappedBank(addr uint16) int {
+	switch {
+	case addr >= ROM_BANK_1_START && addr <= ROM_BANK_1_END:
+		return int(c.CurrentROMBank)
+	case addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END:
+		return int(c.CurrentRAMBank)
+	default:
+		return 0
+	}
+}
+
+// bankByte returns the byte at addr in bank, addresses of the switchable ROM area are relative to the bank start
+func (c *Cartridge) bankByte(bank int, addr uint16) *uint8 {
+	switch {
+	case addr <= ROM_BANK_1_END:
+		if bank < 0 || bank >= len(c.romBanks) {
+			return nil
+		}
+
+		return &c.romBanks[bank][addr%ROM_BANK_SIZE]
+	case addr >= EXTERNAL_RAM_START && addr <= EXTERNAL_RAM_END:
+		if !c.ram || bank < 0 || bank >= len(c.ExternalRAM) {
+			return nil
+		}
+
+		return &c.ExternalRAM[bank][addr-EXTERNAL_RAM_START]
+	default:
+		return nil
+	}
+}
+
+// GetROMBank returns the ROM bank currently mapped at addr
+func (c *Cartridge) GetROMBank(addr uint16) uint16 {
+	if addr >= ROM_BANK_1_START && addr <= ROM_BANK_1_END {
+		return uint16(c.CurrentROMBank)
+	}
+
+	return 0
+}
+
+func (c *Cartridge) GetRAMBank() uint8 {
+	return c.CurrentRAMBank
+}
+
+func (c *Cartridge) GetRAMSize() int {
+	return int(c.ramBankCount) * EXTERNAL_RAM_SIZE
+}
+
+// LoadROM copies the ROM into its banks, it can't be larger than the bank count of its header
+func (c *Cartridge) LoadROM(rom []uint8) error {
+	if len(rom) > len(c.romBanks)*ROM_BANK_SIZE {
+		return fmt.Errorf("rom is %d bytes but its header declares %d banks of %d bytes", len(rom), len(c.romBanks), ROM_BANK_SIZE)
+	}
+
+	for i, b := range rom {
+		c.romBanks[i/ROM_BANK_SIZE][i%ROM_BANK_SIZE] = b
+	}
+
+	return nil
+}
+
+func (c *Cartridge) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode cartridge state: %w", err)
+	}
+
+	if len(st.ExternalRAM) != int(c.ramBankCount) {
+		return fmt.Errorf("cartridge state has %d external RAM banks instead of %d", len(st.ExternalRAM), c.ramBankCount)
+	}
+
+	c.externalRAMMutex.Lock()
+	c.state = st
+	c.externalRAMMutex.Unlock()
+
+	c.externalRAMDirty = true
+
+	return nil
+}
+
+func (c *Cartridge) Save(buf *bytes.Buffer) {
+	c.externalRAMMutex.Lock()
+	defer c.externalRAMMutex.Unlock()
+
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(c.state)
+
+	lib.Assert(err == nil, "failed to encode cartridge state: %v", err)
 }
 
 func (c *Cartridge) Close() {
-	if c.battery {
+	if c.battery && !c.noSave {
 		if err := c.flushExternalRam(); err != nil {
 			fmt.Println(err)
 		}
@@ -209,10 +378,10 @@ func (c *Cartridge) loadExternalRam() error {
 	}
 
 	for i := range c.ramBankCount {
-		copy(c.externalRAM[i][:], ramBytes[int(i)*EXTERNAL_RAM_SIZE:int(i+1)*EXTERNAL_RAM_SIZE])
+		copy(c.ExternalRAM[i][:], ramBytes[int(i)*EXTERNAL_RAM_SIZE:int(i+1)*EXTERNAL_RAM_SIZE])
 	}
 
-	log.Debug("[cartridge] loaded external RAM from %s", savePath)
+	c.log.Debug("[cartridge] loaded external RAM from %s", savePath)
 
 	return nil
 }
@@ -230,7 +399,7 @@ func (c *Cartridge) flushExternalRam() error {
 	ramBytes := make([]uint8, int(c.ramBankCount)*EXTERNAL_RAM_SIZE)
 
 	for i := range c.ramBankCount {
-		copy(ramBytes[int(i)*EXTERNAL_RAM_SIZE:int(i+1)*EXTERNAL_RAM_SIZE], c.externalRAM[i][:])
+		copy(ramBytes[int(i)*EXTERNAL_RAM_SIZE:int(i+1)*EXTERNAL_RAM_SIZE], c.ExternalRAM[i][:])
 	}
 
 	_, err = f.Write(ramBytes[:])
@@ -240,7 +409,7 @@ func (c *Cartridge) flushExternalRam() error {
 
 	c.externalRAMMutex.Unlock()
 
-	log.Debug("[cartridge] flushed external RAM to %s", savePath)
+	c.log.Debug("[cartridge] flushed external RAM to %s", savePath)
 
 	return nil
 }
@@ -328,6 +497,6 @@ func (c *Cartridge) configure(cartridgeType uint8) {
 		c.sensor = true
 
 	default:
-		log.Debug("[cartridge] unsupported cartridge type: %x", cartridgeType)
+		c.log.Debug("[cartridge] unsupported cartridge type: %x", cartridgeType)
 	}
 }
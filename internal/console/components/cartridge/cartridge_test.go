@@ -0,0 +1,70 @@
+package cartridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	MBC1_RAM         = 0x02
+	MBC1_RAM_BATTERY = 0x03
+)
+
+// newCartridge returns a cartridge with 4 ROM banks, each starting with its number
+func newCartridge(t *testing.T, cartridgeType, ramSize uint8) *Cartridge {
+	t.Helper()
+
+	rom := make([]uint8, 4*ROM_BANK_SIZE)
+	for bank := range 4 {
+		rom[bank*ROM_BANK_SIZE] = uint8(bank)
+	}
+
+	c := &Cartridge{}
+	require.NoError(t, c.Init("", "", cartridgeType, 0x01, ramSize, WithNoSave()))
+	require.NoError(t, c.LoadROM(rom))
+
+	return c
+}
+
+func Test_ROM_Bank_Masked(t *testing.T) {
+	tests := []struct {
+		value uint8
+		bank  uint8
+	}{
+		{value: 0x00, bank: 1},
+		{value: 0x02, bank: 2},
+		{value: 0x07, bank: 3},
+		{value: 0xD1, bank: 1},
+	}
+
+	for _, tt := range tests {
+		c := newCartridge(t, MBC1_RAM, 0x00)
+
+		c.Write(0x2000, tt.value)
+
+		assert.Equal(t, tt.bank, c.Read(ROM_BANK_1_START), "bank %02X", tt.value)
+	}
+}
+
+func Test_External_RAM(t *testing.T) {
+	t.Run("declared without a size", func(t *testing.T) {
+		c := newCartridge(t, MBC1_RAM, 0x00)
+
+		c.Write(0x0000, 0x0A)
+
+		require.NotPanics(t, func() { c.Write(EXTERNAL_RAM_START, 0x12) })
+		assert.Equal(t, uint8(0xFF), c.Read(EXTERNAL_RAM_START))
+	})
+
+	t.Run("bank masked", func(t *testing.T) {
+		c := newCartridge(t, MBC1_RAM_BATTERY, 0x02)
+
+		c.Write(0x0000, 0x0A)
+		c.Write(0x4000, 0x03)
+
+		require.NotPanics(t, func() { c.Write(EXTERNAL_RAM_START, 0x12) })
+		assert.Equal(t, uint8(0x12), c.Read(EXTERNAL_RAM_START))
+	})
+}
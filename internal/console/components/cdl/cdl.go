@@ -0,0 +1,182 @@
+package cdl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	ROM_BANK_SIZE    = 0x4000
+	ROM_BANK_0_END   = 0x3FFF
+	ROM_BANK_1_START = 0x4000
+	ROM_BANK_1_END   = 0x7FFF
+
+	VRAM_START = 0x8000
+	VRAM_END   = 0x9FFF
+	VRAM_SIZE  = VRAM_END - VRAM_START + 1
+
+	EXTERNAL_RAM_START     = 0xA000
+	EXTERNAL_RAM_END       = 0xBFFF
+	EXTERNAL_RAM_BANK_SIZE = EXTERNAL_RAM_END - EXTERNAL_RAM_START + 1
+
+	WRAM_START = 0xC000
+	WRAM_END   = 0xDFFF
+	WRAM_SIZE  = WRAM_END - WRAM_START + 1
+
+	ECHO_START = 0xE000
+	ECHO_END   = 0xFDFF
+
+	HRAM_START = 0xFF80
+	HRAM_END   = 0xFFFE
+	HRAM_SIZE  = HRAM_END - HRAM_START + 1
+
+	MAGIC   = "GBGOCDL"
+	VERSION = 1
+)
+
+type Flag uint8
+
+const (
+	EXECUTED Flag = 1 << iota // Fetched as an opcode
+	OPERAND                   // Fetched as an instruction operand
+	READ                      // Read as data
+	WRITTEN
+)
+
+type Cartridge interface {
+	GetROMBank(addr uint16) uint16
+	GetRAMBank() uint8
+}
+
+// Region holds the access flags of every byte of a memory area
+type Region struct {
+	Name     string
+	Flags    []Flag
+	BankSize int
+}
+
+// CDL is a code/data logger, it records how every ROM and RAM byte was accessed
+type CDL struct {
+	cartridge Cartridge
+
+	ROM  Region
+	SRAM Region
+	WRAM Region
+	VRAM Region
+	HRAM Region
+}
+
+func (c *CDL) Init(cartridge Cartridge, romSize, sramSize int) {
+	c.cartridge = cartridge
+	c.ROM = Region{Name: "ROM", Flags: make([]Flag, romSize), BankSize: ROM_BANK_SIZE}
+	c.SRAM = Region{Name: "SRAM", Flags: make([]Flag, sramSize), BankSize: EXTERNAL_RAM_BANK_SIZE}
+	c.WRAM = Region{Name: "WRAM", Flags: make([]Flag, WRAM_SIZE), BankSize: WRAM_SIZE}
+	c.VRAM = Region{Name: "VRAM", Flags: make([]Flag, VRAM_SIZE), BankSize: VRAM_SIZE}
+	c.HRAM = Region{Name: "HRAM", Flags: make([]Flag, HRAM_SIZE), BankSize: HRAM_SIZE}
+}
+
+func (c *CDL) Regions() []*Region {
+	return []*Region{&c.ROM, &c.SRAM, &c.WRAM, &c.VRAM, &c.HRAM}
+}
+
+// Log records an access to a CPU address, addresses outside of ROM and RAM are ignored
+func (c *CDL) Log(addr uint16, flag Flag) {
+	switch {
+	case addr <= ROM_BANK_0_END:
+		c.ROM.mark(int(addr), flag)
+	case addr <= ROM_BANK_1_END:
+		c.ROM.mark(int(c.cartridge.GetROMBank(addr))*ROM_BANK_SIZE+int(addr-ROM_BANK_1_START), flag)
+	case addr <= VRAM_END:
+		c.VRAM.mark(int(addr-VRAM_START), flag)
+	case addr <= EXTERNAL_RAM_END:
+		c.SRAM.mark(int(c.cartridge.GetRAMBank())*EXTERNAL_RAM_BANK_SIZE+int(addr-EXTERNAL_RAM_START), flag)
+	case addr <= WRAM_END:
+		c.WRAM.mark(int(addr-WRAM_START), flag)
+	case addr <= ECHO_END:
+		c.WRAM.mark(int(addr-ECHO_START), flag)
+	case addr >= HRAM_START && addr <= HRAM_END:
+		c.HRAM.mark(int(addr-HRAM_START), flag)
+	}
+}
+
+// Write dumps the CDL: a magic, a version, then the size and flags of each region
+func (c *CDL) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(MAGIC); err != nil {
+		return fmt.Errorf("failed to write CDL: %w", err)
+	}
+
+	if err := bw.WriteByte(VERSION); err != nil {
+		return fmt.Errorf("failed to write CDL: %w", err)
+	}
+
+	for _, r := range c.Regions() {
+		if err := binary.Write(bw, binary.LittleEndian, uint32(len(r.Flags))); err != nil {
+			return fmt.Errorf("failed to write CDL: %w", err)
+		}
+
+		if err := binary.Write(bw, binary.LittleEndian, r.Flags); err != nil {
+			return fmt.Errorf("failed to write CDL: %w", err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Read loads a CDL dump
+func Read(r io.Reader) (*CDL, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]uint8, len(MAGIC)+1)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("failed to read CDL: %w", err)
+	}
+
+	if string(magic[:len(MAGIC)]) != MAGIC || magic[len(MAGIC)] != VERSION {
+		return nil, errors.New("not a CDL file")
+	}
+
+	c := &CDL{}
+	c.Init(nil, 0, 0)
+
+	for _, region := range c.Regions() {
+		var size uint32
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return nil, fmt.Errorf("failed to read CDL: %w", err)
+		}
+
+		region.Flags = make([]Flag, size)
+		if err := binary.Read(br, binary.LittleEndian, region.Flags); err != nil {
+			return nil, fmt.Errorf("failed to read CDL: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Merge adds the flags of another CDL recorded with the same ROM
+func (c *CDL) Merge(other *CDL) error {
+	otherRegions := other.Regions()
+
+	for i, region := range c.Regions() {
+		if len(otherRegions[i].Flags) != len(region.Flags) {
+			return fmt.Errorf("CDL %s size mismatch: %d instead of %d", region.Name, len(otherRegions[i].Flags), len(region.Flags))
+		}
+
+		for j, f := range otherRegions[i].Flags {
+			region.Flags[j] |= f
+		}
+	}
+
+	return nil
+}
+
+func (r *Region) mark(offset int, flag Flag) {
+	if offset < len(r.Flags) {
+		r.Flags[offset] |= flag
+	}
+}
@@ -0,0 +1,163 @@
+package cdl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCartridge struct {
+	romBank uint16
+	ramBank uint8
+}
+
+func (c *testCartridge) GetROMBank(addr uint16) uint16 {
+	if addr <= ROM_BANK_0_END {
+		return 0
+	}
+
+	return c.romBank
+}
+
+func (c *testCartridge) GetRAMBank() uint8 {
+	return c.ramBank
+}
+
+func newCDL() (*CDL, *testCartridge) {
+	cartridge := &testCartridge{romBank: 1}
+	c := &CDL{}
+	c.Init(cartridge, 4*ROM_BANK_SIZE, 2*EXTERNAL_RAM_BANK_SIZE)
+
+	return c, cartridge
+}
+
+func Test_Log(t *testing.T) {
+	c, cartridge := newCDL()
+
+	c.Log(0x0100, EXECUTED)
+	c.Log(0x0101, OPERAND)
+	c.Log(0x0101, READ)
+
+	cartridge.romBank = 3
+	c.Log(0x4000, EXECUTED)
+
+	c.Log(0x8010, WRITTEN)
+
+	cartridge.ramBank = 1
+	c.Log(0xA000, WRITTEN)
+	c.Log(0xA000, READ)
+
+	c.Log(0xC123, WRITTEN)
+	// Echo RAM mirrors WRAM
+	c.Log(0xE123, READ)
+
+	c.Log(0xFF80, READ)
+
+	// IO registers, OAM and IE are ignored
+	c.Log(0xFF40, WRITTEN)
+	c.Log(0xFE00, WRITTEN)
+	c.Log(0xFFFF, WRITTEN)
+
+	assert.Equal(t, EXECUTED, c.ROM.Flags[0x0100])
+	assert.Equal(t, OPERAND|READ, c.ROM.Flags[0x0101])
+	assert.Equal(t, EXECUTED, c.ROM.Flags[3*ROM_BANK_SIZE])
+	assert.Equal(t, Flag(0), c.ROM.Flags[ROM_BANK_SIZE])
+	assert.Equal(t, WRITTEN, c.VRAM.Flags[0x0010])
+	assert.Equal(t, WRITTEN|READ, c.SRAM.Flags[EXTERNAL_RAM_BANK_SIZE])
+	assert.Equal(t, Flag(0), c.SRAM.Flags[0])
+	assert.Equal(t, WRITTEN|READ, c.WRAM.Flags[0x0123])
+	assert.Equal(t, READ, c.HRAM.Flags[0])
+}
+
+func Test_Log_Outside_Cartridge(t *testing.T) {
+	cartridge := &testCartridge{romBank: 7, ramBank: 3}
+	c := &CDL{}
+	c.Init(cartridge, 2*ROM_BANK_SIZE, 0)
+
+	// Banks past the end of the ROM or without RAM are ignored
+	c.Log(0x4000, EXECUTED)
+	c.Log(0xA000, WRITTEN)
+
+	assert.NotContains(t, c.ROM.Flags, EXECUTED)
+	assert.Empty(t, c.SRAM.Flags)
+}
+
+func Test_Write_Read(t *testing.T) {
+	c, _ := newCDL()
+
+	c.Log(0x0100, EXECUTED)
+	c.Log(0x0101, OPERAND)
+	c.Log(0xA000, WRITTEN)
+	c.Log(0xC000, READ)
+	c.Log(0x9800, READ)
+	c.Log(0xFFFE, WRITTEN)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Write(&buf))
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+
+	for i, r := range c.Regions() {
+		assert.Equal(t, r.Name, read.Regions()[i].Name)
+		assert.Equal(t, r.Flags, read.Regions()[i].Flags, r.Name)
+	}
+}
+
+func Test_Read_Invalid(t *testing.T) {
+	c, _ := newCDL()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Write(&buf))
+
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		input   []uint8
+		wantErr string
+	}{
+		{name: "empty", input: nil, wantErr: "failed to read CDL"},
+		{name: "magic", input: append([]uint8("GBGOCDX"), valid[len(MAGIC):]...), wantErr: "not a CDL file"},
+		{name: "version", input: append([]uint8(MAGIC), append([]uint8{VERSION + 1}, valid[len(MAGIC)+1:]...)...), wantErr: "not a CDL file"},
+		{name: "truncated", input: valid[:len(valid)-1], wantErr: "failed to read CDL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.input))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func Test_Merge(t *testing.T) {
+	c, _ := newCDL()
+	c.Log(0x0100, EXECUTED)
+	c.Log(0xC000, WRITTEN)
+
+	other, _ := newCDL()
+	other.Log(0x0100, READ)
+	other.Log(0x0200, EXECUTED)
+	other.Log(0xC000, READ)
+
+	require.NoError(t, c.Merge(other))
+
+	assert.Equal(t, EXECUTED|READ, c.ROM.Flags[0x0100])
+	assert.Equal(t, EXECUTED, c.ROM.Flags[0x0200])
+	assert.Equal(t, WRITTEN|READ, c.WRAM.Flags[0])
+
+	// The merged CDL is left untouched
+	assert.Equal(t, READ, other.ROM.Flags[0x0100])
+}
+
+func Test_Merge_Size_Mismatch(t *testing.T) {
+	c, _ := newCDL()
+
+	other := &CDL{}
+	other.Init(nil, 2*ROM_BANK_SIZE, 2*EXTERNAL_RAM_BANK_SIZE)
+
+	assert.ErrorContains(t, c.Merge(other), "CDL ROM size mismatch")
+}
@@ -0,0 +1,123 @@
+package cdl
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// BankCoverage counts the accessed bytes of a region bank, a byte may be counted by several flags
+type BankCoverage struct {
+	Region   string `json:"region"`
+	Bank     int    `json:"bank"`
+	Size     int    `json:"size"`
+	Executed int    `json:"executed"`
+	Operand  int    `json:"operand"`
+	Read     int    `json:"read"`
+	Written  int    `json:"written"`
+	Unused   int    `json:"unused"`
+}
+
+func (b BankCoverage) Percent(count int) float64 {
+	if b.Size == 0 {
+		return 0
+	}
+
+	return float64(count) * 100 / float64(b.Size)
+}
+
+func (c *CDL) Coverage() []BankCoverage {
+	var coverage []BankCoverage
+
+	for _, r := range c.Regions() {
+		for start := 0; start < len(r.Flags); start += r.BankSize {
+			end := min(start+r.BankSize, len(r.Flags))
+			bank := BankCoverage{
+				Region: r.Name,
+				Bank:   start / r.BankSize,
+				Size:   end - start,
+			}
+
+			for _, f := range r.Flags[start:end] {
+				if f&EXECUTED != 0 {
+					bank.Executed++
+				}
+
+				if f&OPERAND != 0 {
+					bank.Operand++
+				}
+
+				if f&READ != 0 {
+					bank.Read++
+				}
+
+				if f&WRITTEN != 0 {
+					bank.Written++
+				}
+
+				if f == 0 {
+					bank.Unused++
+				}
+			}
+
+			coverage = append(coverage, bank)
+		}
+	}
+
+	return coverage
+}
+
+func (c *CDL) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(c.Coverage()); err != nil {
+		return fmt.Errorf("failed to write CDL JSON report: %w", err)
+	}
+
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gbgo coverage</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #999; padding: 2px 8px; text-align: right; }
+.bar { display: flex; width: 300px; height: 12px; background: #eee; }
+.executed { background: #2a7; }
+.operand { background: #7c9; }
+.read { background: #36c; }
+</style>
+</head>
+<body>
+<h1>gbgo coverage</h1>
+<table>
+<tr><th>Region</th><th>Bank</th><th>Size</th><th>Executed</th><th>Operand</th><th>Read</th><th>Written</th><th>Unused</th><th>Coverage</th></tr>
+{{- range .}}
+<tr>
+<td>{{.Region}}</td><td>{{printf "%03X" .Bank}}</td><td>{{.Size}}</td>
+<td>{{.Executed}}</td><td>{{.Operand}}</td><td>{{.Read}}</td><td>{{.Written}}</td><td>{{.Unused}}</td>
+<td><div class="bar">
+<div class="executed" style="width: {{printf "%.2f" (.Percent .Executed)}}%"></div>
+<div class="operand" style="width: {{printf "%.2f" (.Percent .Operand)}}%"></div>
+<div class="read" style="width: {{printf "%.2f" (.Percent .Read)}}%"></div>
+</div></td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func (c *CDL) WriteHTML(w io.Writer) error {
+	if err := reportTemplate.Execute(w, c.Coverage()); err != nil {
+		return fmt.Errorf("failed to write CDL HTML report: %w", err)
+	}
+
+	return nil
+}
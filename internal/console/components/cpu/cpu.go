@@ -11,25 +11,90 @@ import (
 type Bus interface {
 	Read(addr uint16) uint8
 	Write(addr uint16, value uint8)
+	Fetch(addr uint16, opcode bool) uint8
+	Peek(addr uint16) uint8
 }
 
 type Console interface {
+	// Stop enters STOP mode, the console wakes up when a button is pressed
 	Stop()
+	// ButtonHeld reports whether a selected joypad line is low
+	ButtonHeld() bool
 }
 
 type Debugger interface {
-	Push(trace string)
+	Push(trace Trace)
+}
+
+// Clock advances the other components, the CPU ticks it before each memory access
+type Clock interface {
+	Tick(cycles int)
+}
+
+// OAM is corrupted on DMG when the CPU puts an address of FE00-FEFF on the bus during OAM scan,
+// either for a memory access or through the 16-bit increment unit
+type OAM interface {
+	CorruptOnWrite(addr uint16)
+	CorruptOnRead(addr uint16)
+	CorruptOnReadIncrease(addr uint16)
+}
+
+type TraceKind uint8
+
+const (
+	TRACE_INSTRUCTION TraceKind = iota
+	TRACE_INTERRUPT
+	TRACE_IO_WRITE
+)
+
+// Trace is the CPU state when an event happens, PC points to the instruction responsible for it
+type Trace struct {
+	Kind TraceKind
+
+	// Interrupt vector or written IO register
+	Addr  uint16
+	Value uint8
+
+	// Filled by the debugger
+	Bank  uint16
+	Frame uint64
+
+	PC uint16
+	SP uint16
+
+	A uint8
+	F uint8
+	B uint8
+	C uint8
+	D uint8
+	E uint8
+	H uint8
+	L uint8
+
+	PCMem [4]uint8
 }
 
 type CPU struct {
 	bus      Bus
 	console  Console
 	debugger Debugger
+	clock    Clock
+	oam      OAM
+
+	// T-cycles of the current instruction already ticked
+	ticked int
+
+	unprefixedOpcodes OpcodeTable
+	cbPrefixedOpcodes OpcodeTable
+
+	// Points into the opcode tables, it isn't saved as gob would encode its flags map in random order
+	currentOpcode *Opcode
+
 	state
 }
 
 type state struct {
-	CurrentOpcode *Opcode
+	CurrentOpcodePC uint16
 
 	PC uint16
 	SP uint16
@@ -59,6 +124,10 @@ type Option func(*CPU)
 
 const (
 	INTERRUPTS_START_ADDR = 0x40
+	OAM_START             = 0xFE00
+	OAM_BUG_END           = 0xFEFF
+	IO_START              = 0xFF00
+	IO_END                = 0xFF7F
 	IFF                   = 0xFF0F
 	IE                    = 0xFFFF
 )
@@ -69,15 +138,41 @@ func WithDebug() Option {
 	}
 }
 
+// WithClock ticks clock at every M-cycle memory access instead of leaving it to the caller after each instruction
+func WithClock(clock Clock) Option {
+	return func(c *CPU) {
+		c.clock = clock
+	}
+}
+
+// WithOAMBug reports the accesses that may trigger the OAM corruption bug to oam
+func WithOAMBug(oam OAM) Option {
+	return func(c *CPU) {
+		c.oam = oam
+	}
+}
+
 func WithBootROM() Option {
 	return func(c *CPU) {
 		c.UseBootROM = true
 	}
 }
 
-func (c *CPU) String() string {
-	return fmt.Sprintf("%04x: %02x %02x %02x  A:%02x F:%02x B:%02x C:%02x D:%02x E:%02x H:%02x L:%02x SP:%04x",
-		c.PC, c.bus.Read(c.PC), c.bus.Read(c.PC+1), c.bus.Read(c.PC+2), c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L, c.SP)
+func (c *CPU) trace(kind TraceKind, pc uint16) Trace {
+	return Trace{
+		Kind:  kind,
+		PC:    pc,
+		SP:    c.SP,
+		A:     c.A,
+		F:     c.F,
+		B:     c.B,
+		C:     c.C,
+		D:     c.D,
+		E:     c.E,
+		H:     c.H,
+		L:     c.L,
+		PCMem: [4]uint8{c.bus.Peek(pc), c.bus.Peek(pc + 1), c.bus.Peek(pc + 2), c.bus.Peek(pc + 3)},
+	}
 }
 
 func (c *CPU) Init(b Bus, con Console, d Debugger, options ...Option) {
@@ -85,10 +180,14 @@ func (c *CPU) Init(b Bus, con Console, d Debugger, options ...Option) {
 		o(c)
 	}
 
-	if err := ParseOpcodes(); err != nil {
+	unprefixed, cbPrefixed, err := ParseOpcodes()
+	if err != nil {
 		panic(fmt.Errorf("failed to parse CPU opcodes: %w", err))
 	}
 
+	c.unprefixedOpcodes = unprefixed
+	c.cbPrefixedOpcodes = cbPrefixed
+
 	c.bindOpcodeFuncs()
 
 	c.bus = b
@@ -126,7 +225,21 @@ func (c *CPU) Init(b Bus, con Console, d Debugger, options ...Option) {
 	c.Halted = false
 }
 
+// Step runs an instruction, or an interrupt dispatch, and returns its duration in T-cycles.
+// With a clock, all of these cycles have been ticked when it returns.
 func (c *CPU) Step() int {
+	c.ticked = 0
+
+	cycles := c.step()
+
+	if c.clock != nil && cycles > c.ticked {
+		c.clock.Tick(cycles - c.ticked)
+	}
+
+	return max(cycles, c.ticked)
+}
+
+func (c *CPU) step() int {
 	cycles := 0
 
 	if c.Halted {
@@ -142,14 +255,18 @@ func (c *CPU) Step() int {
 	}
 
 	if c.Debug {
-		c.debugger.Push(c.String())
+		c.debugger.Push(c.trace(TRACE_INSTRUCTION, c.PC))
 	}
 
+	// EI enables interrupts after the instruction that follows it
+	enableIME := c.IMEScheduled
+
+	c.CurrentOpcodePC = c.PC
 	opcode := c.getOpcode()
 
 	cycles += opcode.Func(opcode)
 
-	if c.IMEScheduled {
+	if enableIME && c.IMEScheduled {
 		c.IME = true
 		c.IMEScheduled = false
 	}
@@ -157,6 +274,11 @@ func (c *CPU) Step() int {
 	return cycles
 }
 
+// Executed reports whether the last instruction was the given unprefixed opcode
+func (c *CPU) Executed(opcode uint8) bool {
+	return c.currentOpcode == &c.unprefixedOpcodes[opcode]
+}
+
 func (c *CPU) Read(addr uint16) uint8 {
 	switch addr {
 	case IFF:
@@ -179,15 +301,31 @@ func (c *CPU) Write(addr uint16, value uint8) {
 	}
 }
 
+// Peek reads like Read, the interrupt registers have no side effects
+func (c *CPU) Peek(addr uint16) uint8 {
+	return c.Read(addr)
+}
+
+func (c *CPU) Poke(addr uint16, value uint8) {
+	c.Write(addr, value)
+}
+
 func (c *CPU) RequestInterrupt(code uint8) {
 	c.IFF |= code
 }
 
-func (c *CPU) Load(buf *bytes.Reader) {
-	enc := gob.NewDecoder(buf)
-	err := enc.Decode(&c.state)
+func (c *CPU) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
 
-	lib.Assert(err == nil, "failed to decode CPU state: %v", err)
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode CPU state: %w", err)
+	}
+
+	c.state = st
+
+	return nil
 }
 
 func (c *CPU) Save(buf *bytes.Buffer) {
@@ -197,8 +335,80 @@ func (c *CPU) Save(buf *bytes.Buffer) {
 	lib.Assert(err == nil, "failed to encode CPU state: %v", err)
 }
 
+func (c *CPU) write(addr uint16, value uint8) {
+	if c.Debug && addr >= IO_START && (addr <= IO_END || addr == IE) {
+		t := c.trace(TRACE_IO_WRITE, c.CurrentOpcodePC)
+		t.Addr = addr
+		t.Value = value
+
+		c.debugger.Push(t)
+	}
+
+	c.tick()
+
+	if c.inOAM(addr) {
+		c.oam.CorruptOnWrite(addr)
+	}
+
+	c.bus.Write(addr, value)
+}
+
+func (c *CPU) read(addr uint16) uint8 {
+	c.tick()
+
+	if c.inOAM(addr) {
+		c.oam.CorruptOnRead(addr)
+	}
+
+	return c.bus.Read(addr)
+}
+
+// readIncDec reads addr while the increment unit changes it in the same M-cycle, like POP or LD A,[HL+]
+func (c *CPU) readIncDec(addr uint16) uint8 {
+	c.tick()
+
+	if c.inOAM(addr) {
+		c.oam.CorruptOnReadIncrease(addr)
+	}
+
+	return c.bus.Read(addr)
+}
+
+// incDec signals a 16-bit increment or decrement of addr without memory access, like INC rr
+func (c *CPU) incDec(addr uint16) {
+	if c.inOAM(addr) {
+		c.oam.CorruptOnWrite(addr)
+	}
+}
+
+// inOAM reports whether an address on the bus may corrupt OAM
+func (c *CPU) inOAM(addr uint16) bool {
+	return c.oam != nil && addr >= OAM_START && addr <= OAM_BUG_END
+}
+
+// idle spends an M-cycle without accessing memory, so that the following accesses happen on time
+func (c *CPU) idle() {
+	c.tick()
+}
+
+// tick advances the clock by an M-cycle
+func (c *CPU) tick() {
+	if c.clock == nil {
+		return
+	}
+
+	c.clock.Tick(4)
+	c.ticked += 4
+}
+
 func (c *CPU) fetchByte() uint8 {
-	val := c.bus.Read(c.PC)
+	return c.fetch(false)
+}
+
+func (c *CPU) fetch(opcode bool) uint8 {
+	c.tick()
+
+	val := c.bus.Fetch(c.PC, opcode)
 
 	if c.HaltBug {
 		c.HaltBug = false
@@ -217,13 +427,13 @@ func (c *CPU) fetchWord() uint16 {
 }
 
 func (c *CPU) getOpcode() *Opcode {
-	opcode := &UnprefixedOpcodes[c.fetchByte()]
+	opcode := &c.unprefixedOpcodes[c.fetch(true)]
 
 	if opcode.Mnemonic == "PREFIX" {
-		opcode = &CBPrefixedOpcodes[c.fetchByte()]
+		opcode = &c.cbPrefixedOpcodes[c.fetchByte()]
 	}
 
-	c.CurrentOpcode = opcode
+	c.currentOpcode = opcode
 
 	return opcode
 }
@@ -249,7 +459,7 @@ func (c *CPU) getOp(op string) uint8 {
 	case "n8":
 		return c.fetchByte()
 	case "HL":
-		return c.bus.Read(uint16(c.H)<<8 | uint16(c.L))
+		return c.read(uint16(c.H)<<8 | uint16(c.L))
 	default:
 		panic("unsupported operand for getOp: " + op)
 	}
@@ -274,7 +484,7 @@ func (c *CPU) setOp(op string, value uint8) {
 	case "L":
 		c.L = value
 	case "HL":
-		c.bus.Write(uint16(c.H)<<8|uint16(c.L), value)
+		c.write(uint16(c.H)<<8|uint16(c.L), value)
 	default:
 		panic("unsupported operand for setOp: " + op)
 	}
@@ -373,20 +583,56 @@ func (c *CPU) setFlags(zf, nf, hf, cf bool) {
 	c.setCF(cf)
 }
 
+// handleInterrupts dispatches the highest priority pending interrupt in 5 M-cycles: 2 idle ones, 2 to push PC and 1 to jump
 func (c *CPU) handleInterrupts() int {
+	if c.IE&c.IFF&0x1F == 0 {
+		return 0
+	}
+
 	c.IME = false
 	c.IMEScheduled = false
 
+	// The halt bug hits the dispatch instead of the next fetch, so the interrupt returns to the HALT
+	if c.HaltBug {
+		c.HaltBug = false
+		c.PC--
+	}
+
+	var t Trace
+	if c.Debug {
+		t = c.trace(TRACE_INTERRUPT, c.PC)
+	}
+
+	c.idle()
+	c.idle()
+
+	c.incDec(c.SP)
+	c.SP--
+	c.write(c.SP, uint8(c.PC>>8))
+
+	// The interrupt is picked after pushing the high byte of PC, which may overwrite IE and cancel the dispatch
+	vector := uint16(0)
+
 	for i := range 5 {
 		mask := uint8(1 << i)
 		if c.IE&c.IFF&mask != 0 {
+			vector = INTERRUPTS_START_ADDR + uint16(i*8)
 			c.IFF &= ^mask
-			c.pushValue(c.PC)
-			c.PC = INTERRUPTS_START_ADDR + uint16(i*8)
 
-			return 20
+			break
 		}
 	}
 
-	return 0
+	c.SP--
+	c.write(c.SP, uint8(c.PC))
+	c.PC = vector
+	c.idle()
+
+	if c.Debug {
+		t.Addr = vector
+
+		c.debugger.Push(t)
+	}
+
+	return 20
 }
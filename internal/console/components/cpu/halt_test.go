@@ -0,0 +1,113 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	OPC_EI    = 0xFB
+	OPC_HALT  = 0x76
+	OPC_STOP  = 0x10
+	OPC_INC_A = 0x3C
+)
+
+// stopConsole records whether the CPU entered STOP mode
+type stopConsole struct {
+	buttonHeld bool
+	stopped    bool
+}
+
+func (c *stopConsole) Stop() {
+	c.stopped = true
+}
+
+func (c *stopConsole) ButtonHeld() bool {
+	return c.buttonHeld
+}
+
+func Test_HALT_Bug(t *testing.T) {
+	c, _ := newTimingCPU([]uint8{OPC_HALT, OPC_INC_A}, FLAGS_CLEARED)
+	c.A = 0
+	c.IE = 0x01
+	c.IFF = 0x01
+
+	c.Step()
+	assert.False(t, c.Halted, "IME=0 with a pending interrupt doesn't halt")
+
+	c.Step()
+	c.Step()
+	assert.Equal(t, uint8(2), c.A, "the byte after HALT runs twice")
+	assert.Equal(t, uint16(TIMING_PC+2), c.PC)
+}
+
+func Test_HALT_Wakes_Without_IME(t *testing.T) {
+	c, _ := newTimingCPU([]uint8{OPC_HALT, OPC_INC_A}, FLAGS_CLEARED)
+	c.IE = 0x01
+
+	c.Step()
+	c.Step()
+	assert.True(t, c.Halted)
+	assert.Equal(t, uint16(TIMING_PC+1), c.PC)
+
+	c.RequestInterrupt(0x01)
+	c.Step()
+	assert.False(t, c.Halted)
+	assert.Equal(t, uint16(TIMING_PC+2), c.PC, "no dispatch with IME=0")
+	assert.Equal(t, uint8(0x01), c.IFF)
+}
+
+func Test_EI_HALT(t *testing.T) {
+	c, bus := newTimingCPU([]uint8{OPC_EI, OPC_HALT}, FLAGS_CLEARED)
+	c.IE = 0x01
+	c.IFF = 0x01
+
+	c.Step()
+	c.Step()
+	c.Step()
+
+	// The halt bug makes the interrupt return to the HALT, which then runs again
+	assert.Equal(t, uint16(INTERRUPTS_START_ADDR+1), c.PC)
+	assert.False(t, c.HaltBug)
+	assert.Equal(t, uint16(TIMING_PC+1), uint16(bus.memory[TIMING_SP-1])<<8|uint16(bus.memory[TIMING_SP-2]))
+}
+
+func Test_STOP(t *testing.T) {
+	tests := []struct {
+		name       string
+		buttonHeld bool
+		pending    bool
+		pc         uint16
+		halted     bool
+		stopped    bool
+	}{
+		{"button held, interrupt pending", true, true, TIMING_PC + 1, false, false},
+		{"button held", true, false, TIMING_PC + 2, true, false},
+		{"interrupt pending", false, true, TIMING_PC + 1, false, true},
+		{"no button nor interrupt", false, false, TIMING_PC + 2, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			console := &stopConsole{buttonHeld: tt.buttonHeld}
+			bus := &testBus{}
+			bus.memory[TIMING_PC] = OPC_STOP
+
+			c := &CPU{}
+			c.Init(bus, console, nil, WithClock(bus))
+			c.PC = TIMING_PC
+			c.IE = 0x01
+
+			if tt.pending {
+				c.IFF = 0x01
+			}
+
+			c.Step()
+
+			assert.Equal(t, tt.pc, c.PC)
+			assert.Equal(t, tt.halted, c.Halted)
+			assert.Equal(t, tt.stopped, console.stopped)
+		})
+	}
+}
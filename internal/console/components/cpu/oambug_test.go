@@ -0,0 +1,136 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oamAccess is a signal sent to the OAM, kind is w for a write or an increment, r for a read and i for a read with an increment
+type oamAccess struct {
+	kind uint8
+	addr uint16
+}
+
+type testOAM struct {
+	accesses []oamAccess
+}
+
+func (o *testOAM) CorruptOnWrite(addr uint16) {
+	o.accesses = append(o.accesses, oamAccess{'w', addr})
+}
+
+func (o *testOAM) CorruptOnRead(addr uint16) {
+	o.accesses = append(o.accesses, oamAccess{'r', addr})
+}
+
+func (o *testOAM) CorruptOnReadIncrease(addr uint16) {
+	o.accesses = append(o.accesses, oamAccess{'i', addr})
+}
+
+func Test_OAM_Bug_Signals(t *testing.T) {
+	tests := []struct {
+		name string
+		code []uint8
+		want []oamAccess
+	}{
+		{"INC BC", []uint8{0x03}, []oamAccess{{'w', 0xFE10}}},
+		{"DEC BC", []uint8{0x0B}, []oamAccess{{'w', 0xFE10}}},
+		{"INC B", []uint8{0x04}, nil},
+		{"LD A,[HL+]", []uint8{0x2A}, []oamAccess{{'i', 0xFE20}}},
+		{"LD A,[HL-]", []uint8{0x3A}, []oamAccess{{'i', 0xFE20}}},
+		{"LD [HL+],A", []uint8{0x22}, []oamAccess{{'w', 0xFE20}}},
+		{"LD A,[HL]", []uint8{0x7E}, []oamAccess{{'r', 0xFE20}}},
+		{"POP BC", []uint8{0xC1}, []oamAccess{{'i', 0xFE30}, {'i', 0xFE31}}},
+		{"PUSH BC", []uint8{0xC5}, []oamAccess{{'w', 0xFE30}, {'w', 0xFE2F}, {'w', 0xFE2E}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oam := &testOAM{}
+			bus := &testBus{}
+			copy(bus.memory[TIMING_PC:], tt.code)
+
+			c := &CPU{}
+			c.Init(bus, testConsole{}, nil, WithClock(bus), WithOAMBug(oam))
+			c.PC = TIMING_PC
+			c.SP = 0xFE30
+			c.setDOp("BC", 0xFE10)
+			c.setDOp("HL", 0xFE20)
+
+			c.Step()
+
+			assert.Equal(t, tt.want, oam.accesses)
+		})
+	}
+}
+
+func Test_OAM_Bug_Interrupt_Dispatch(t *testing.T) {
+	oam := &testOAM{}
+	bus := &testBus{}
+
+	c := &CPU{}
+	c.Init(bus, testConsole{}, nil, WithClock(bus), WithOAMBug(oam))
+	c.PC = TIMING_PC
+	c.SP = 0xFE30
+	c.IME = true
+	c.IE = 0x01
+	c.IFF = 0x01
+
+	c.Step()
+
+	assert.Equal(t, []oamAccess{{'w', 0xFE30}, {'w', 0xFE2F}, {'w', 0xFE2E}}, oam.accesses)
+}
+
+// timedOAM records when the signals are sent, in T-cycles since the start of the instruction
+type timedOAM struct {
+	bus   *testBus
+	ticks []int
+}
+
+func (o *timedOAM) CorruptOnWrite(uint16) {
+	o.ticks = append(o.ticks, o.bus.ticks)
+}
+
+func (o *timedOAM) CorruptOnRead(uint16) {
+	o.ticks = append(o.ticks, o.bus.ticks)
+}
+
+func (o *timedOAM) CorruptOnReadIncrease(uint16) {
+	o.ticks = append(o.ticks, o.bus.ticks)
+}
+
+func Test_OAM_Bug_Signal_Timing(t *testing.T) {
+	tests := []struct {
+		name string
+		code []uint8
+		want []int
+	}{
+		// The increment happens at the end of the M-cycle after the fetch
+		{"INC BC", []uint8{0x03}, []int{8}},
+		{"DEC BC", []uint8{0x0B}, []int{8}},
+		{"INC SP", []uint8{0x33}, []int{8}},
+		{"LD [HL+],A", []uint8{0x22}, []int{8}},
+		{"PUSH BC", []uint8{0xC5}, []int{8, 12, 16}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := &testBus{}
+			copy(bus.memory[TIMING_PC:], tt.code)
+
+			oam := &timedOAM{bus: bus}
+
+			c := &CPU{}
+			c.Init(bus, testConsole{}, nil, WithClock(bus), WithOAMBug(oam))
+			c.PC = TIMING_PC
+			c.SP = 0xFE30
+			c.setDOp("BC", 0xFE10)
+			c.setDOp("HL", 0xFE20)
+
+			c.Step()
+
+			assert.Equal(t, tt.want, oam.ticks)
+		})
+	}
+}
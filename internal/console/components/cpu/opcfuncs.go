@@ -11,46 +11,16 @@ func (c *CPU) nop(opc *Opcode) int {
 }
 
 func (c *CPU) call(opc *Opcode) int {
-	op0 := opc.Operands[0]
-
 	word := c.fetchWord()
 
-	switch op0.Name {
-	case "a16":
-		c.pushValue(c.PC)
-		c.PC = word
-	case "NZ":
-		if !c.getZF() {
-			c.pushValue(c.PC)
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	case "Z":
-		if c.getZF() {
-			c.pushValue(c.PC)
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	case "NC":
-		if !c.getCF() {
-			c.pushValue(c.PC)
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	case "C":
-		if c.getCF() {
-			c.pushValue(c.PC)
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	default:
-		panic("unimplemented call for " + op0.Name)
+	if !c.condition(opc.Operands[0].Name) {
+		return opc.Cycles[1]
 	}
 
+	c.idle()
+	c.pushValue(c.PC)
+	c.PC = word
+
 	return opc.Cycles[0]
 }
 
@@ -59,32 +29,17 @@ func (c *CPU) ret(opc *Opcode) int {
 		c.PC = c.popValue()
 
 		return opc.Cycles[0]
-	} else {
-		op0 := opc.Operands[0]
-		switch op0.Name {
-		case "NC":
-			if !c.getCF() {
-				c.PC = c.popValue()
-				return opc.Cycles[1]
-			}
-		case "C":
-			if c.getCF() {
-				c.PC = c.popValue()
-				return opc.Cycles[1]
-			}
-		case "NZ":
-			if !c.getZF() {
-				c.PC = c.popValue()
-				return opc.Cycles[1]
-			}
-		case "Z":
-			if c.getZF() {
-				c.PC = c.popValue()
-				return opc.Cycles[1]
-			}
-		}
 	}
 
+	// Conditional returns spend an M-cycle checking the condition before popping
+	c.idle()
+
+	if !c.condition(opc.Operands[0].Name) {
+		return opc.Cycles[1]
+	}
+
+	c.PC = c.popValue()
+
 	return opc.Cycles[0]
 }
 
@@ -96,6 +51,7 @@ func (c *CPU) reti(opc *Opcode) int {
 }
 
 func (c *CPU) rst(opc *Opcode) int {
+	c.idle()
 	c.pushValue(c.PC)
 
 	v, err := strconv.ParseUint(opc.Operands[0].Name[1:], 16, 16)
@@ -122,10 +78,14 @@ func (c *CPU) load(opc *Opcode) int {
 	case "n16":
 		v16 = c.fetchWord()
 	case "a16":
-		v8 = c.bus.Read(c.fetchWord())
+		v8 = c.read(c.fetchWord())
 	case "BC", "DE", "HL":
 		if !op1.Immediate {
-			v8 = c.bus.Read(c.getDOp(op1.Name))
+			if op1.Increment || op1.Decrement {
+				v8 = c.readIncDec(c.getDOp(op1.Name))
+			} else {
+				v8 = c.read(c.getDOp(op1.Name))
+			}
 
 			if op1.Increment {
 				c.setDOp(op1.Name, c.getDOp(op1.Name)+1)
@@ -154,7 +114,7 @@ func (c *CPU) load(opc *Opcode) int {
 		c.setOp(op0.Name, v8)
 	case "BC", "DE", "HL", "SP":
 		if !op0.Immediate {
-			c.bus.Write(c.getDOp(op0.Name), v8)
+			c.write(c.getDOp(op0.Name), v8)
 
 			if op0.Increment {
 				c.setDOp(op0.Name, c.getDOp(op0.Name)+1)
@@ -169,12 +129,12 @@ func (c *CPU) load(opc *Opcode) int {
 	case "a16":
 		word := c.fetchWord()
 		if op1.Name == "SP" {
-			c.bus.Write(word, uint8(c.SP))
-			c.bus.Write(word+1, uint8(c.SP>>8))
+			c.write(word, uint8(c.SP))
+			c.write(word+1, uint8(c.SP>>8))
 		}
 
 		if op1.Name == "A" {
-			c.bus.Write(word, c.A)
+			c.write(word, c.A)
 		}
 	default:
 		panic("unimplemented op0 for load: " + op0.Name)
@@ -189,16 +149,16 @@ func (c *CPU) loadH(opc *Opcode) int {
 
 	switch op0.Name {
 	case "a8":
-		c.bus.Write(0xFF00|uint16(c.fetchByte()), c.A)
+		c.write(0xFF00|uint16(c.fetchByte()), c.A)
 	case "C":
-		c.bus.Write(0xFF00|uint16(c.C), c.A)
+		c.write(0xFF00|uint16(c.C), c.A)
 	case "A":
 		if op1.Name == "a8" {
-			c.A = c.bus.Read(0xFF00 | uint16(c.fetchByte()))
+			c.A = c.read(0xFF00 | uint16(c.fetchByte()))
 		}
 
 		if op1.Name == "C" {
-			c.A = c.bus.Read(0xFF00 | uint16(c.C))
+			c.A = c.read(0xFF00 | uint16(c.C))
 		}
 	default:
 		panic("unimplemented loadH for " + op0.Name)
@@ -219,12 +179,15 @@ func (c *CPU) inc(opc *Opcode) int {
 
 	if len(op0.Name) == 2 {
 		if op0.Immediate {
+			// The increment unit changes the register during the M-cycle after the fetch
+			c.idle()
+			c.incDec(c.getDOp(op0.Name))
 			c.setDOp(op0.Name, c.getDOp(op0.Name)+1)
 		} else {
 			addr := c.getDOp(op0.Name)
-			v := c.bus.Read(addr)
+			v := c.read(addr)
 			res := v + 1
-			c.bus.Write(addr, res)
+			c.write(addr, res)
 			c.setFlags(res == 0, false, v&0xF+1 > 0xF, c.getCF())
 		}
 	}
@@ -244,12 +207,15 @@ func (c *CPU) dec(opc *Opcode) int {
 
 	if len(op0.Name) == 2 {
 		if op0.Immediate {
+			// The increment unit changes the register during the M-cycle after the fetch
+			c.idle()
+			c.incDec(c.getDOp(op0.Name))
 			c.setDOp(op0.Name, c.getDOp(op0.Name)-1)
 		} else {
 			addr := c.getDOp(op0.Name)
-			v := c.bus.Read(addr)
+			v := c.read(addr)
 			res := v - 1
-			c.bus.Write(addr, res)
+			c.write(addr, res)
 			c.setFlags(res == 0, true, v&0xF < v&0xF-1, c.getCF())
 		}
 	}
@@ -258,83 +224,33 @@ func (c *CPU) dec(opc *Opcode) int {
 }
 
 func (c *CPU) jumpAbs(opc *Opcode) int {
-	switch opc.Operands[0].Name {
-	case "a16":
-		c.PC = c.fetchWord()
-
-		return opc.Cycles[0]
-	case "HL":
+	if opc.Operands[0].Name == "HL" {
 		c.PC = c.getDOp("HL")
 
 		return opc.Cycles[0]
-	case "NZ":
-		word := c.fetchWord()
-		if !c.getZF() {
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	case "Z":
-		word := c.fetchWord()
-		if c.getZF() {
-			c.PC = word
-
-			return opc.Cycles[1]
-		}
-	case "NC":
-		word := c.fetchWord()
-		if !c.getCF() {
-			c.PC = word
+	}
 
-			return opc.Cycles[1]
-		}
-	case "C":
-		word := c.fetchWord()
-		if c.getCF() {
-			c.PC = word
+	word := c.fetchWord()
 
-			return opc.Cycles[1]
-		}
-	default:
-		panic("unimplemented jump for " + opc.Operands[0].Name)
+	if !c.condition(opc.Operands[0].Name) {
+		return opc.Cycles[1]
 	}
 
+	c.PC = word
+
 	return opc.Cycles[0]
 }
 
 func (c *CPU) jumpRel(opc *Opcode) int {
-	bytes := c.fetchByte()
-
-	cycles := opc.Cycles[0]
+	offset := int8(c.fetchByte())
 
-	switch opc.Operands[0].Name {
-	case "e8":
-		c.PC += uint16(int8(bytes))
-	case "NZ":
-		if !c.getZF() {
-			c.PC += uint16(int8(bytes))
-			cycles = opc.Cycles[1]
-		}
-	case "Z":
-		if c.getZF() {
-			c.PC += uint16(int8(bytes))
-			cycles = opc.Cycles[1]
-		}
-	case "NC":
-		if !c.getCF() {
-			c.PC += uint16(int8(bytes))
-			cycles = opc.Cycles[1]
-		}
-	case "C":
-		if c.getCF() {
-			c.PC += uint16(int8(bytes))
-			cycles = opc.Cycles[1]
-		}
-	default:
-		panic("unimplemented jump for " + opc.Operands[0].Name)
+	if !c.condition(opc.Operands[0].Name) {
+		return opc.Cycles[1]
 	}
 
-	return cycles
+	c.PC += uint16(offset)
+
+	return opc.Cycles[0]
 }
 
 func (c *CPU) ei(opc *Opcode) int {
@@ -345,6 +261,7 @@ func (c *CPU) ei(opc *Opcode) int {
 
 func (c *CPU) di(opc *Opcode) int {
 	c.IME = false
+	c.IMEScheduled = false
 
 	return opc.Cycles[0]
 }
@@ -404,6 +321,7 @@ func (c *CPU) sub(opc *Opcode) int {
 }
 
 func (c *CPU) push(opc *Opcode) int {
+	c.idle()
 	c.pushValue(c.getDOp(opc.Operands[0].Name))
 
 	return opc.Cycles[0]
@@ -526,6 +444,8 @@ func (c *CPU) ccf(opc *Opcode) int {
 	return opc.Cycles[0]
 }
 
+// halt waits for an interrupt. With IME=0 and one already pending, the CPU doesn't halt
+// and fails to increment PC after the next fetch.
 func (c *CPU) halt(opc *Opcode) int {
 	if !c.IME && (c.IE&c.IFF&0x1F != 0) {
 		c.HaltBug = true
@@ -536,8 +456,25 @@ func (c *CPU) halt(opc *Opcode) int {
 	return opc.Cycles[0]
 }
 
+// stop follows the DMG flow: whether the next byte is skipped and the mode entered depend on held buttons
+// and pending interrupts. The CGB speed switch doesn't exist on the emulated model, there is no KEY1.
 func (c *CPU) stop(opc *Opcode) int {
-	c.fetchByte()
+	pending := c.IE&c.IFF&0x1F != 0
+
+	if c.console.ButtonHeld() {
+		// With a pending interrupt, STOP is a 1-byte NOP, otherwise it halts
+		if !pending {
+			c.PC++
+			c.Halted = true
+		}
+
+		return opc.Cycles[0]
+	}
+
+	if !pending {
+		c.PC++
+	}
+
 	c.console.Stop()
 
 	return opc.Cycles[0]
@@ -720,17 +657,35 @@ func (c *CPU) bit(opc *Opcode) int {
 
 // Helpers
 
+// condition reports whether a jump, call or return is taken, the unconditional ones always are
+func (c *CPU) condition(op string) bool {
+	switch op {
+	case "NZ":
+		return !c.getZF()
+	case "Z":
+		return c.getZF()
+	case "NC":
+		return !c.getCF()
+	case "C":
+		return c.getCF()
+	default:
+		return true
+	}
+}
+
 func (c *CPU) pushValue(value uint16) {
+	// The first decrement has an M-cycle of its own, the second one happens during the first write
+	c.incDec(c.SP)
 	c.SP--
-	c.bus.Write(c.SP, uint8(value>>8))
+	c.write(c.SP, uint8(value>>8))
 	c.SP--
-	c.bus.Write(c.SP, uint8(value))
+	c.write(c.SP, uint8(value))
 }
 
 func (c *CPU) popValue() uint16 {
-	lo := c.bus.Read(c.SP)
+	lo := c.readIncDec(c.SP)
 	c.SP++
-	hi := c.bus.Read(c.SP)
+	hi := c.readIncDec(c.SP)
 	c.SP++
 
 	return uint16(hi)<<8 | uint16(lo)
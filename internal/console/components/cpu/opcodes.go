@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type Operand struct {
@@ -32,28 +33,37 @@ type Opcodes struct {
 
 type OpcodeFunc func(*Opcode) int
 
+type OpcodeTable [256]Opcode
+
 var (
 	//go:embed opcodes.json
-	opcodes           []uint8
-	UnprefixedOpcodes [256]Opcode
-	CBPrefixedOpcodes [256]Opcode
+	opcodes []uint8
+
+	// Parsed once, CPUs copy them before binding their opcode funcs
+	parseOpcodesOnce  sync.Once
+	unprefixedOpcodes OpcodeTable
+	cbPrefixedOpcodes OpcodeTable
+	parseOpcodesErr   error
 )
 
-func ParseOpcodes() error {
-	instructions := Opcodes{}
+// ParseOpcodes returns the unprefixed and CB prefixed opcode tables, without opcode funcs
+func ParseOpcodes() (OpcodeTable, OpcodeTable, error) {
+	parseOpcodesOnce.Do(func() {
+		instructions := Opcodes{}
 
-	err := json.Unmarshal(opcodes, &instructions)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal opcode: %w", err)
-	}
+		if err := json.Unmarshal(opcodes, &instructions); err != nil {
+			parseOpcodesErr = fmt.Errorf("failed to unmarshal opcode: %w", err)
+			return
+		}
 
-	for i := range 256 {
-		hex := fmt.Sprintf("0x%02X", i)
-		UnprefixedOpcodes[i] = instructions.Unprefixed[hex]
-		CBPrefixedOpcodes[i] = instructions.CBPrefixed[hex]
-	}
+		for i := range 256 {
+			hex := fmt.Sprintf("0x%02X", i)
+			unprefixedOpcodes[i] = instructions.Unprefixed[hex]
+			cbPrefixedOpcodes[i] = instructions.CBPrefixed[hex]
+		}
+	})
 
-	return nil
+	return unprefixedOpcodes, cbPrefixedOpcodes, parseOpcodesErr
 }
 
 func (c *CPU) bindOpcodeFuncs() {
@@ -106,8 +116,8 @@ func (c *CPU) bindOpcodeFuncs() {
 	}
 
 	for i := range 256 {
-		UnprefixedOpcodes[i].Func = opcodeFuncs[UnprefixedOpcodes[i].Mnemonic]
-		CBPrefixedOpcodes[i].Func = opcodeFuncs[CBPrefixedOpcodes[i].Mnemonic]
+		c.unprefixedOpcodes[i].Func = opcodeFuncs[c.unprefixedOpcodes[i].Mnemonic]
+		c.cbPrefixedOpcodes[i].Func = opcodeFuncs[c.cbPrefixedOpcodes[i].Mnemonic]
 	}
 }
 
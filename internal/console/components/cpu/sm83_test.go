@@ -0,0 +1,221 @@
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Per-opcode test vectors from https://github.com/SingleStepTests/sm83
+const SM83_TESTS_DIR = "testdata/sm83/v1"
+
+type sm83State struct {
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	A   uint8       `json:"a"`
+	B   uint8       `json:"b"`
+	C   uint8       `json:"c"`
+	D   uint8       `json:"d"`
+	E   uint8       `json:"e"`
+	F   uint8       `json:"f"`
+	H   uint8       `json:"h"`
+	L   uint8       `json:"l"`
+	IME uint8       `json:"ime"`
+	IE  uint8       `json:"ie"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+type sm83Test struct {
+	Name    string    `json:"name"`
+	Initial sm83State `json:"initial"`
+	Final   sm83State `json:"final"`
+	// Address, value and read/write/memory request pins of every M-cycle
+	Cycles [][3]any `json:"cycles"`
+}
+
+type busAccess struct {
+	// M-cycle of the instruction during which the access happens
+	cycle int
+	addr  uint16
+	value uint8
+	write bool
+}
+
+func (a busAccess) String() string {
+	if a.write {
+		return fmt.Sprintf("M%d write %04X <- %02X", a.cycle, a.addr, a.value)
+	}
+
+	return fmt.Sprintf("M%d read  %04X -> %02X", a.cycle, a.addr, a.value)
+}
+
+// testBus is a flat 64 KiB memory that records every access, it is also the CPU clock
+type testBus struct {
+	memory   [0x10000]uint8
+	accesses []busAccess
+	ticks    int
+}
+
+func (b *testBus) Tick(cycles int) {
+	b.ticks += cycles
+}
+
+func (b *testBus) cycle() int {
+	return b.ticks/4 - 1
+}
+
+func (b *testBus) Read(addr uint16) uint8 {
+	b.accesses = append(b.accesses, busAccess{cycle: b.cycle(), addr: addr, value: b.memory[addr]})
+
+	return b.memory[addr]
+}
+
+func (b *testBus) Write(addr uint16, value uint8) {
+	b.accesses = append(b.accesses, busAccess{cycle: b.cycle(), addr: addr, value: value, write: true})
+	b.memory[addr] = value
+}
+
+func (b *testBus) Fetch(addr uint16, _ bool) uint8 {
+	return b.Read(addr)
+}
+
+func (b *testBus) Peek(addr uint16) uint8 {
+	return b.memory[addr]
+}
+
+type testConsole struct{}
+
+func (testConsole) Stop() {}
+
+func (testConsole) ButtonHeld() bool { return false }
+
+func Test_SM83(t *testing.T) {
+	dir := SM83_TESTS_DIR
+	if env := os.Getenv("SM83_TESTS_DIR"); env != "" {
+		dir = env
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+
+	if len(files) == 0 {
+		t.Skipf("no test vectors in %s", dir)
+	}
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			tests := readSM83Tests(t, file)
+			bus := &testBus{accesses: []busAccess{}}
+			c := &CPU{}
+
+			c.Init(bus, testConsole{}, nil, WithClock(bus))
+
+			for _, test := range tests {
+				if !runSM83Test(t, c, bus, test) {
+					return // Other vectors of an opcode usually fail the same way
+				}
+			}
+		})
+	}
+}
+
+func readSM83Tests(t *testing.T, path string) []sm83Test {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var tests []sm83Test
+
+	require.NoError(t, json.Unmarshal(data, &tests))
+
+	return tests
+}
+
+func runSM83Test(t *testing.T, c *CPU, bus *testBus, test sm83Test) bool {
+	t.Helper()
+
+	initial := test.Initial
+
+	bus.memory = [0x10000]uint8{}
+	for _, r := range initial.RAM {
+		bus.memory[r[0]] = uint8(r[1])
+	}
+
+	c.PC = initial.PC
+	c.SP = initial.SP
+	c.A = initial.A
+	c.B = initial.B
+	c.C = initial.C
+	c.D = initial.D
+	c.E = initial.E
+	c.F = initial.F
+	c.H = initial.H
+	c.L = initial.L
+	c.IME = initial.IME != 0
+	c.IE = initial.IE
+	c.IFF = 0
+	c.IMEScheduled = false
+	c.Halted = false
+	c.HaltBug = false
+
+	bus.accesses = bus.accesses[:0]
+	bus.ticks = 0
+
+	cycles := c.Step()
+
+	want := test.Final
+	want.RAM = nil
+
+	got := sm83State{
+		PC: c.PC, SP: c.SP,
+		A: c.A, B: c.B, C: c.C, D: c.D, E: c.E, F: c.F, H: c.H, L: c.L,
+		IME: boolToUint8(c.IME), IE: c.IE,
+	}
+
+	ok := assert.Equal(t, want, got, "%s: registers", test.Name)
+
+	for _, r := range test.Final.RAM {
+		ok = assert.Equal(t, uint8(r[1]), bus.memory[r[0]], "%s: RAM at %04X", test.Name, r[0]) && ok
+	}
+
+	ok = assert.Equal(t, expectedAccesses(test), bus.accesses, "%s: bus accesses", test.Name) && ok
+	ok = assert.Equal(t, len(test.Cycles)*4, cycles, "%s: T-cycles", test.Name) && ok
+	ok = assert.Equal(t, cycles, bus.ticks, "%s: ticked T-cycles", test.Name) && ok
+
+	return ok
+}
+
+// expectedAccesses lists the reads and writes of a test with their M-cycle, idle M-cycles are skipped
+func expectedAccesses(test sm83Test) []busAccess {
+	accesses := []busAccess{}
+
+	for i, cycle := range test.Cycles {
+		addr, _ := cycle[0].(float64)
+		value, _ := cycle[1].(float64)
+		pins, _ := cycle[2].(string)
+
+		switch {
+		case strings.HasPrefix(pins, "r"):
+			accesses = append(accesses, busAccess{cycle: i, addr: uint16(addr), value: uint8(value)})
+		case strings.Contains(pins, "w"):
+			accesses = append(accesses, busAccess{cycle: i, addr: uint16(addr), value: uint8(value), write: true})
+		}
+	}
+
+	return accesses
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,200 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TIMING_PC = 0x0100
+	TIMING_SP = 0xD000
+	TIMING_BC = 0xC100
+	TIMING_HL = 0xC000
+
+	// Z and C set or cleared, so that conditional instructions are either taken or not
+	FLAGS_CLEARED = 0x00
+	FLAGS_SET     = 0xF0
+)
+
+// Access pattern of an instruction, one character per M-cycle: r for a read, w for a write and - when idle
+var accessTimings = []struct {
+	name    string
+	code    []uint8
+	flags   uint8
+	pattern string
+}{
+	{"NOP", []uint8{0x00}, FLAGS_CLEARED, "r"},
+	{"LD BC,n16", []uint8{0x01}, FLAGS_CLEARED, "rrr"},
+	{"LD [BC],A", []uint8{0x02}, FLAGS_CLEARED, "rw"},
+	{"INC BC", []uint8{0x03}, FLAGS_CLEARED, "r-"},
+	{"LD [a16],SP", []uint8{0x08}, FLAGS_CLEARED, "rrrww"},
+	{"ADD HL,BC", []uint8{0x09}, FLAGS_CLEARED, "r-"},
+	{"LD A,[BC]", []uint8{0x0A}, FLAGS_CLEARED, "rr"},
+	{"JR e8", []uint8{0x18}, FLAGS_CLEARED, "rr-"},
+	{"JR NZ,e8 taken", []uint8{0x20}, FLAGS_CLEARED, "rr-"},
+	{"JR NZ,e8 not taken", []uint8{0x20}, FLAGS_SET, "rr"},
+	{"LD [HL+],A", []uint8{0x22}, FLAGS_CLEARED, "rw"},
+	{"INC [HL]", []uint8{0x34}, FLAGS_CLEARED, "rrw"},
+	{"LD [HL],n8", []uint8{0x36}, FLAGS_CLEARED, "rrw"},
+	{"LD B,[HL]", []uint8{0x46}, FLAGS_CLEARED, "rr"},
+	{"RET NZ taken", []uint8{0xC0}, FLAGS_CLEARED, "r-rr-"},
+	{"RET NZ not taken", []uint8{0xC0}, FLAGS_SET, "r-"},
+	{"POP BC", []uint8{0xC1}, FLAGS_CLEARED, "rrr"},
+	{"JP NZ,a16 taken", []uint8{0xC2}, FLAGS_CLEARED, "rrr-"},
+	{"JP NZ,a16 not taken", []uint8{0xC2}, FLAGS_SET, "rrr"},
+	{"JP a16", []uint8{0xC3}, FLAGS_CLEARED, "rrr-"},
+	{"CALL NZ,a16 taken", []uint8{0xC4}, FLAGS_CLEARED, "rrr-ww"},
+	{"CALL NZ,a16 not taken", []uint8{0xC4}, FLAGS_SET, "rrr"},
+	{"PUSH BC", []uint8{0xC5}, FLAGS_CLEARED, "r-ww"},
+	{"ADD A,n8", []uint8{0xC6}, FLAGS_CLEARED, "rr"},
+	{"RST $00", []uint8{0xC7}, FLAGS_CLEARED, "r-ww"},
+	{"RET", []uint8{0xC9}, FLAGS_CLEARED, "rrr-"},
+	{"CALL a16", []uint8{0xCD}, FLAGS_CLEARED, "rrr-ww"},
+	{"RETI", []uint8{0xD9}, FLAGS_CLEARED, "rrr-"},
+	{"LDH [a8],A", []uint8{0xE0}, FLAGS_CLEARED, "rrw"},
+	{"LDH [C],A", []uint8{0xE2}, FLAGS_CLEARED, "rw"},
+	{"ADD SP,e8", []uint8{0xE8}, FLAGS_CLEARED, "rr--"},
+	{"JP HL", []uint8{0xE9}, FLAGS_CLEARED, "r"},
+	{"LD [a16],A", []uint8{0xEA}, FLAGS_CLEARED, "rrrw"},
+	{"LDH A,[a8]", []uint8{0xF0}, FLAGS_CLEARED, "rrr"},
+	{"LD HL,SP+e8", []uint8{0xF8}, FLAGS_CLEARED, "rr-"},
+	{"LD SP,HL", []uint8{0xF9}, FLAGS_CLEARED, "r-"},
+	{"LD A,[a16]", []uint8{0xFA}, FLAGS_CLEARED, "rrrr"},
+	{"RLC B", []uint8{0xCB, 0x00}, FLAGS_CLEARED, "rr"},
+	{"RLC [HL]", []uint8{0xCB, 0x06}, FLAGS_CLEARED, "rrrw"},
+	{"BIT 0,[HL]", []uint8{0xCB, 0x46}, FLAGS_CLEARED, "rrr"},
+	{"SET 0,[HL]", []uint8{0xCB, 0xC6}, FLAGS_CLEARED, "rrrw"},
+}
+
+func newTimingCPU(code []uint8, flags uint8) (*CPU, *testBus) {
+	bus := &testBus{}
+	copy(bus.memory[TIMING_PC:], code)
+
+	c := &CPU{}
+	c.Init(bus, testConsole{}, nil, WithClock(bus))
+
+	c.PC = TIMING_PC
+	c.SP = TIMING_SP
+	c.F = flags
+	c.setDOp("BC", TIMING_BC)
+	c.setDOp("HL", TIMING_HL)
+
+	return c, bus
+}
+
+// accessPattern renders the accesses recorded by the bus like the patterns of accessTimings
+func accessPattern(bus *testBus) string {
+	pattern := []uint8(strings.Repeat("-", bus.ticks/4))
+
+	for _, a := range bus.accesses {
+		if a.cycle < 0 || a.cycle >= len(pattern) {
+			return fmt.Sprintf("access outside of the instruction: %v", a)
+		}
+
+		if a.write {
+			pattern[a.cycle] = 'w'
+		} else {
+			pattern[a.cycle] = 'r'
+		}
+	}
+
+	return string(pattern)
+}
+
+func Test_Access_Timing(t *testing.T) {
+	for _, tt := range accessTimings {
+		t.Run(tt.name, func(t *testing.T) {
+			c, bus := newTimingCPU(tt.code, tt.flags)
+
+			cycles := c.Step()
+
+			assert.Equal(t, tt.pattern, accessPattern(bus))
+			assert.Equal(t, len(tt.pattern)*4, cycles)
+		})
+	}
+}
+
+// Test_Opcode_Durations checks every opcode against the durations of opcodes.json, both when taken and not taken
+func Test_Opcode_Durations(t *testing.T) {
+	unprefixed, cbPrefixed, err := ParseOpcodes()
+	assert.NoError(t, err)
+
+	for prefix, table := range map[string]OpcodeTable{"": unprefixed, "CB ": cbPrefixed} {
+		for i, opc := range table {
+			// HALT and STOP last until the CPU wakes up, PREFIX is timed with the CB prefixed opcodes
+			switch opc.Mnemonic {
+			case "", "HALT", "STOP", "PREFIX":
+				continue
+			}
+
+			if strings.HasPrefix(opc.Mnemonic, "ILLEGAL") {
+				continue
+			}
+
+			code := []uint8{uint8(i)}
+			if prefix != "" {
+				code = []uint8{0xCB, uint8(i)}
+			}
+
+			for _, flags := range []uint8{FLAGS_CLEARED, FLAGS_SET} {
+				c, bus := newTimingCPU(code, flags)
+
+				want := opc.Cycles[0]
+				if len(opc.Cycles) == 2 && !c.condition(opc.Operands[0].Name) {
+					want = opc.Cycles[1]
+				}
+
+				cycles := c.Step()
+
+				name := fmt.Sprintf("%s%02X %s (F=%02X)", prefix, i, opc, flags)
+				pattern := accessPattern(bus)
+
+				assert.Equal(t, want, cycles, "%s: T-cycles", name)
+				assert.Equal(t, want, bus.ticks, "%s: ticked T-cycles", name)
+				assert.Equal(t, len(bus.accesses), len(pattern)-strings.Count(pattern, "-"), "%s: accesses sharing an M-cycle", name)
+			}
+		}
+	}
+}
+
+func Test_Interrupt_Dispatch_Timing(t *testing.T) {
+	c, bus := newTimingCPU(nil, FLAGS_CLEARED)
+	c.IME = true
+	c.IE = 0x01
+	c.IFF = 0x01
+
+	cycles := c.Step()
+
+	// Dispatch, then the NOP at the vector
+	assert.Equal(t, "--ww-r", accessPattern(bus))
+	assert.Equal(t, 24, cycles)
+	assert.Equal(t, uint16(INTERRUPTS_START_ADDR+1), c.PC)
+	assert.Equal(t, uint8(0), c.IFF)
+}
+
+func Test_EI_Delay(t *testing.T) {
+	// EI; NOP; NOP
+	c, _ := newTimingCPU([]uint8{0xFB, 0x00, 0x00}, FLAGS_CLEARED)
+	c.IE = 0x01
+	c.IFF = 0x01
+
+	c.Step()
+	assert.False(t, c.IME, "IME right after EI")
+
+	c.Step()
+	assert.Equal(t, uint16(TIMING_PC+2), c.PC, "the instruction after EI runs before the interrupt")
+	assert.True(t, c.IME)
+
+	c.Step()
+	assert.Equal(t, uint16(INTERRUPTS_START_ADDR+1), c.PC)
+
+	// EI; DI
+	c, _ = newTimingCPU([]uint8{0xFB, 0xF3}, FLAGS_CLEARED)
+
+	c.Step()
+	c.Step()
+	assert.False(t, c.IME, "DI right after EI")
+}
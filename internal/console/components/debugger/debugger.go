@@ -1,63 +1,204 @@
 package debugger
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+
+	"github.com/cterence/gbgo/internal/console/components/cpu"
 )
 
 const (
-	DEBUGGER_SIZE = 2048
+	QUEUE_SIZE  = 4096
+	BUFFER_SIZE = 64 * 1024
+
+	ROM_END = 0x7FFF
 )
 
+type Format uint8
+
+const (
+	FORMAT_DEFAULT Format = iota
+	// https://github.com/robert/gameboy-doctor
+	FORMAT_DOCTOR
+)
+
+// Policy decides what Push does when the trace queue is full
+type Policy uint8
+
+const (
+	// Wait for the writer, no trace is ever lost
+	BLOCK Policy = iota
+	// Drop the trace and keep emulating at full speed
+	DROP
+)
+
+type Cartridge interface {
+	GetROMBank(addr uint16) uint16
+}
+
+type PPU interface {
+	GetFrameCount() uint64
+}
+
 type Debugger struct {
-	writer           io.Writer
-	traces           [DEBUGGER_SIZE]string
-	head, tail, size int
+	cartridge Cartridge
+	ppu       PPU
+
+	writer *bufio.Writer
+	traces chan cpu.Trace
+	done   chan struct{}
+	err    error
+
+	format    Format
+	policy    Policy
+	queueSize int
+	filter    Filter
+
+	active     bool
+	started    bool
+	startFrame uint64
+
+	dropped uint64
+	running bool
+}
+
+type Option func(*Debugger)
+
+func WithFormat(format Format) Option {
+	return func(d *Debugger) {
+		d.format = format
+	}
+}
+
+func WithPolicy(policy Policy) Option {
+	return func(d *Debugger) {
+		d.policy = policy
+	}
+}
+
+func WithQueueSize(size int) Option {
+	return func(d *Debugger) {
+		d.queueSize = size
+	}
 }
 
-func (d *Debugger) Init(w io.Writer) {
-	d.traces = [DEBUGGER_SIZE]string{}
-	d.head = 0
-	d.tail = 0
-	d.size = 0
-	d.writer = w
+func ParseFormat(format string) (Format, error) {
+	switch format {
+	case "default":
+		return FORMAT_DEFAULT, nil
+	case "doctor":
+		return FORMAT_DOCTOR, nil
+	default:
+		return 0, fmt.Errorf("unsupported trace format: %s", format)
+	}
+}
+
+func (d *Debugger) Init(w io.Writer, cartridge Cartridge, ppu PPU, options ...Option) {
+	d.cartridge = cartridge
+	d.ppu = ppu
+	d.format = FORMAT_DEFAULT
+	d.policy = BLOCK
+	d.queueSize = QUEUE_SIZE
+	d.filter = Filter{}
+
+	for _, o := range options {
+		o(d)
+	}
 
-	go d.WriteTraces()
+	d.writer = bufio.NewWriterSize(w, BUFFER_SIZE)
+	d.traces = make(chan cpu.Trace, d.queueSize)
+	d.done = make(chan struct{})
+	d.err = nil
+	d.dropped = 0
+	d.active = len(d.filter.StartAddrs) == 0
+	d.started = false
+	d.startFrame = 0
+	d.running = true
+
+	go d.writeTraces()
 }
 
-func (d *Debugger) Push(trace string) {
-	d.traces[d.tail] = trace
-	d.tail = (d.tail + 1) % DEBUGGER_SIZE
-	d.size++
+func (d *Debugger) Push(trace cpu.Trace) {
+	trace.Bank = d.cartridge.GetROMBank(trace.PC)
+	trace.Frame = d.ppu.GetFrameCount()
+
+	if !d.accept(&trace) {
+		return
+	}
+
+	if d.policy == DROP {
+		select {
+		case d.traces <- trace:
+		default:
+			d.dropped++
+		}
 
-	if d.size > DEBUGGER_SIZE {
-		d.size = DEBUGGER_SIZE
+		return
 	}
+
+	d.traces <- trace
 }
 
-func (d *Debugger) Pop() (string, bool) {
-	if d.size == 0 {
-		return "", false
+// Close writes the remaining queued traces and flushes the writer. Push must not be called afterwards.
+func (d *Debugger) Close() error {
+	if !d.running {
+		return nil
 	}
 
-	trace := d.traces[d.head]
-	d.head = (d.head + 1) % DEBUGGER_SIZE
-	d.size--
+	close(d.traces)
+	<-d.done
 
-	return trace, true
+	d.running = false
+
+	if d.dropped > 0 {
+		fmt.Printf("debugger dropped %d traces\n", d.dropped)
+	}
+
+	return d.err
 }
 
-func (d *Debugger) WriteTraces() {
-	for {
-		trace, ok := d.Pop()
+func (d *Debugger) writeTraces() {
+	defer close(d.done)
 
-		if !ok {
+	for trace := range d.traces {
+		// Keep draining on error so that Push never blocks forever
+		if d.err != nil {
 			continue
 		}
 
-		_, err := d.writer.Write([]byte(trace + "\n"))
-		if err != nil {
-			fmt.Printf("failed to write to debugger writer: %v\n", err)
+		d.err = d.writeTrace(trace)
+	}
+
+	if d.err == nil {
+		d.err = d.writer.Flush()
+	}
+
+	if d.err != nil {
+		d.err = fmt.Errorf("failed to write traces: %w", d.err)
+	}
+}
+
+func (d *Debugger) writeTrace(t cpu.Trace) error {
+	var err error
+
+	switch {
+	case d.format == FORMAT_DOCTOR:
+		// gameboy-doctor only understands instruction traces
+		if t.Kind != cpu.TRACE_INSTRUCTION {
+			return nil
 		}
+
+		_, err = fmt.Fprintf(d.writer, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+			t.A, t.F, t.B, t.C, t.D, t.E, t.H, t.L, t.SP, t.PC, t.PCMem[0], t.PCMem[1], t.PCMem[2], t.PCMem[3])
+	case t.Kind == cpu.TRACE_INTERRUPT:
+		_, err = fmt.Fprintf(d.writer, "%04x: INT %04x\n", t.PC, t.Addr)
+	case t.Kind == cpu.TRACE_IO_WRITE:
+		_, err = fmt.Fprintf(d.writer, "%04x: IO  %04x <- %02x\n", t.PC, t.Addr, t.Value)
+	default:
+		_, err = fmt.Fprintf(d.writer, "%04x: %02x %02x %02x  A:%02x F:%02x B:%02x C:%02x D:%02x E:%02x H:%02x L:%02x SP:%04x\n",
+			t.PC, t.PCMem[0], t.PCMem[1], t.PCMem[2], t.A, t.F, t.B, t.C, t.D, t.E, t.H, t.L, t.SP)
 	}
+
+	return err
 }
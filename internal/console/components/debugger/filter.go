@@ -0,0 +1,165 @@
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cterence/gbgo/internal/console/components/cpu"
+)
+
+// Range is an inclusive address range
+type Range struct {
+	Start uint16
+	End   uint16
+
+	// ROM bank the range is in, only valid if HasBank
+	Bank    uint16
+	HasBank bool
+}
+
+// Filter selects which traces are written. The zero value traces every instruction.
+type Filter struct {
+	PCRanges []Range
+	Banks    []uint16
+
+	// Only trace these events instead of instructions
+	Interrupts bool
+	IOWrites   bool
+
+	// Stop tracing after this many frames once tracing started, 0 for no limit
+	Frames uint64
+
+	// Tracing starts when PC reaches one of the start addresses and pauses on a stop address
+	StartAddrs []uint16
+	StopAddrs  []uint16
+}
+
+func WithFilter(filter Filter) Option {
+	return func(d *Debugger) {
+		d.filter = filter
+	}
+}
+
+func ParseAddress(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %s: %w", s, err)
+	}
+
+	return uint16(addr), nil
+}
+
+// ParseRange parses a single address or an inclusive range such as 0150-01FF, optionally in a ROM bank such as 02:4000-4FFF
+func ParseRange(s string) (Range, error) {
+	var r Range
+
+	addrs := s
+
+	if bankStr, rest, found := strings.Cut(s, ":"); found {
+		bank, err := strconv.ParseUint(bankStr, 16, 16)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid bank %s: %w", bankStr, err)
+		}
+
+		r.Bank = uint16(bank)
+		r.HasBank = true
+		addrs = rest
+	}
+
+	startStr, endStr, found := strings.Cut(addrs, "-")
+
+	start, err := ParseAddress(startStr)
+	if err != nil {
+		return Range{}, err
+	}
+
+	if r.HasBank && start > ROM_END {
+		return Range{}, fmt.Errorf("invalid range %s: banks are only supported in ROM", s)
+	}
+
+	r.Start = start
+	r.End = start
+
+	if !found {
+		return r, nil
+	}
+
+	end, err := ParseAddress(endStr)
+	if err != nil {
+		return Range{}, err
+	}
+
+	if end < start {
+		return Range{}, fmt.Errorf("invalid range %s: end is before start", s)
+	}
+
+	if r.HasBank && end > ROM_END {
+		return Range{}, fmt.Errorf("invalid range %s: banks are only supported in ROM", s)
+	}
+
+	r.End = end
+
+	return r, nil
+}
+
+func (r Range) contains(pc, bank uint16) bool {
+	if r.HasBank && bank != r.Bank {
+		return false
+	}
+
+	return pc >= r.Start && pc <= r.End
+}
+
+func (d *Debugger) accept(t *cpu.Trace) bool {
+	f := &d.filter
+
+	if t.Kind == cpu.TRACE_INSTRUCTION && !d.active && slices.Contains(f.StartAddrs, t.PC) {
+		d.active = true
+	}
+
+	if !d.active {
+		return false
+	}
+
+	if !d.started {
+		d.started = true
+		d.startFrame = t.Frame
+	}
+
+	if f.Frames > 0 && t.Frame-d.startFrame >= f.Frames {
+		return false
+	}
+
+	// The stop instruction itself is still traced
+	if t.Kind == cpu.TRACE_INSTRUCTION && slices.Contains(f.StopAddrs, t.PC) {
+		d.active = false
+	}
+
+	switch t.Kind {
+	case cpu.TRACE_INSTRUCTION:
+		if f.Interrupts || f.IOWrites {
+			return false
+		}
+	case cpu.TRACE_INTERRUPT:
+		if !f.Interrupts {
+			return false
+		}
+	case cpu.TRACE_IO_WRITE:
+		if !f.IOWrites {
+			return false
+		}
+	}
+
+	if len(f.PCRanges) > 0 && !slices.ContainsFunc(f.PCRanges, func(r Range) bool { return r.contains(t.PC, t.Bank) }) {
+		return false
+	}
+
+	// Banks only make sense for code running from ROM
+	if len(f.Banks) > 0 && (t.PC > ROM_END || !slices.Contains(f.Banks, t.Bank)) {
+		return false
+	}
+
+	return true
+}
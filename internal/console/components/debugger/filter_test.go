@@ -0,0 +1,219 @@
+package debugger
+
+import (
+	"io"
+	"testing"
+
+	"github.com/cterence/gbgo/internal/console/components/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCartridge struct{}
+
+func (testCartridge) GetROMBank(addr uint16) uint16 {
+	return 0
+}
+
+type testPPU struct{}
+
+func (testPPU) GetFrameCount() uint64 {
+	return 0
+}
+
+func newDebugger(t *testing.T, filter Filter) *Debugger {
+	d := &Debugger{}
+	d.Init(io.Discard, testCartridge{}, testPPU{}, WithFilter(filter))
+
+	t.Cleanup(func() {
+		require.NoError(t, d.Close())
+	})
+
+	return d
+}
+
+func instruction(pc uint16) cpu.Trace {
+	return cpu.Trace{Kind: cpu.TRACE_INSTRUCTION, PC: pc}
+}
+
+func Test_ParseAddress(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint16
+		wantErr bool
+	}{
+		{input: "0150", want: 0x0150},
+		{input: "0x0150", want: 0x0150},
+		{input: "0XFFFF", want: 0xFFFF},
+		{input: "ff80", want: 0xFF80},
+		{input: "10000", wantErr: true},
+		{input: "zz", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			addr, err := ParseAddress(tt.input)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, addr)
+		})
+	}
+}
+
+func Test_ParseRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Range
+		wantErr string
+	}{
+		{input: "0150", want: Range{Start: 0x0150, End: 0x0150}},
+		{input: "0150-01FF", want: Range{Start: 0x0150, End: 0x01FF}},
+		{input: "0x0150-0x01ff", want: Range{Start: 0x0150, End: 0x01FF}},
+		{input: "02:4000-4FFF", want: Range{Start: 0x4000, End: 0x4FFF, Bank: 2, HasBank: true}},
+		{input: "1F:7FFF", want: Range{Start: 0x7FFF, End: 0x7FFF, Bank: 0x1F, HasBank: true}},
+		{input: "00:0150-0150", want: Range{Start: 0x0150, End: 0x0150, HasBank: true}},
+		{input: "01FF-0150", wantErr: "end is before start"},
+		{input: "0150-", wantErr: "invalid address"},
+		{input: "-0150", wantErr: "invalid address"},
+		{input: "0150-01FF-0200", wantErr: "invalid address"},
+		{input: "ZZ:4000", wantErr: "invalid bank"},
+		{input: ":4000", wantErr: "invalid bank"},
+		{input: "01:C000-C0FF", wantErr: "banks are only supported in ROM"},
+		{input: "01:7000-8000", wantErr: "banks are only supported in ROM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			r, err := ParseRange(tt.input)
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, r)
+		})
+	}
+}
+
+func Test_Filter_Accept(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		traces []cpu.Trace
+		want   []bool
+	}{
+		{
+			name:   "zero value traces instructions only",
+			traces: []cpu.Trace{instruction(0x0100), {Kind: cpu.TRACE_INTERRUPT, PC: 0x0150}, {Kind: cpu.TRACE_IO_WRITE, PC: 0x0150}},
+			want:   []bool{true, false, false},
+		},
+		{
+			name:   "PC ranges",
+			filter: Filter{PCRanges: []Range{{Start: 0x0150, End: 0x01FF}, {Start: 0xFF80, End: 0xFF80}}},
+			traces: []cpu.Trace{instruction(0x014F), instruction(0x0150), instruction(0x01FF), instruction(0x0200), instruction(0xFF80)},
+			want:   []bool{false, true, true, false, true},
+		},
+		{
+			name:   "PC range in a bank",
+			filter: Filter{PCRanges: []Range{{Start: 0x4000, End: 0x4FFF, Bank: 2, HasBank: true}}},
+			traces: []cpu.Trace{
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x4000, Bank: 1},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x4000, Bank: 2},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x5000, Bank: 2},
+			},
+			want: []bool{false, true, false},
+		},
+		{
+			name:   "banks",
+			filter: Filter{Banks: []uint16{0, 3}},
+			traces: []cpu.Trace{
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0150, Bank: 0},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x4000, Bank: 1},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x4000, Bank: 3},
+				// Code running from RAM has no bank
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0xC000, Bank: 0},
+			},
+			want: []bool{true, false, true, false},
+		},
+		{
+			name:   "interrupts",
+			filter: Filter{Interrupts: true},
+			traces: []cpu.Trace{instruction(0x0150), {Kind: cpu.TRACE_INTERRUPT, Addr: 0x40, PC: 0x0150}, {Kind: cpu.TRACE_IO_WRITE, PC: 0x0150}},
+			want:   []bool{false, true, false},
+		},
+		{
+			name:   "IO writes",
+			filter: Filter{IOWrites: true},
+			traces: []cpu.Trace{instruction(0x0150), {Kind: cpu.TRACE_INTERRUPT, PC: 0x0150}, {Kind: cpu.TRACE_IO_WRITE, Addr: 0xFF40, PC: 0x0150}},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "interrupts and IO writes",
+			filter: Filter{Interrupts: true, IOWrites: true},
+			traces: []cpu.Trace{instruction(0x0150), {Kind: cpu.TRACE_INTERRUPT, PC: 0x0150}, {Kind: cpu.TRACE_IO_WRITE, PC: 0x0150}},
+			want:   []bool{false, true, true},
+		},
+		{
+			name:   "frames",
+			filter: Filter{Frames: 2},
+			traces: []cpu.Trace{
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0150, Frame: 10},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0150, Frame: 11},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0150, Frame: 12},
+			},
+			want: []bool{true, true, false},
+		},
+		{
+			name:   "frames count from the start trigger",
+			filter: Filter{Frames: 1, StartAddrs: []uint16{0x0200}},
+			traces: []cpu.Trace{
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0150, Frame: 1},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0200, Frame: 5},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0201, Frame: 5},
+				{Kind: cpu.TRACE_INSTRUCTION, PC: 0x0202, Frame: 6},
+			},
+			want: []bool{false, true, true, false},
+		},
+		{
+			name:   "start and stop",
+			filter: Filter{StartAddrs: []uint16{0x0200}, StopAddrs: []uint16{0x0210}},
+			traces: []cpu.Trace{
+				instruction(0x0150),
+				{Kind: cpu.TRACE_INTERRUPT, PC: 0x0200},
+				instruction(0x0200),
+				instruction(0x0205),
+				instruction(0x0210),
+				instruction(0x0211),
+				instruction(0x0200),
+			},
+			want: []bool{false, false, true, true, true, false, true},
+		},
+		{
+			name:   "stop without start",
+			filter: Filter{StopAddrs: []uint16{0x0210}},
+			traces: []cpu.Trace{instruction(0x0150), instruction(0x0210), instruction(0x0211)},
+			want:   []bool{true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDebugger(t, tt.filter)
+
+			got := make([]bool, len(tt.traces))
+			for i := range tt.traces {
+				got[i] = d.accept(&tt.traces[i])
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,16 +1,29 @@
 package dma
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+
+	"github.com/cterence/gbgo/internal/lib"
 )
 
 const (
 	DMA_ADDR  = 0xFF46
 	DMA_BYTES = 0xA0
+
+	VRAM_START = 0x8000
+	VRAM_END   = 0x9FFF
+	OAM_START  = 0xFE00
+	ECHO_START = 0xE000
+	WRAM_START = 0xC000
+
+	// M-cycles between the write to DMA and the transfer of the first byte
+	START_DELAY = 2
 )
 
 type Bus interface {
-	Read(addr uint16) uint8
+	ReadDMA(addr uint16) uint8
 }
 
 type PPU interface {
@@ -22,42 +35,97 @@ type DMA struct {
 	bus Bus
 	ppu PPU
 
-	dma       uint8
-	dmaActive bool
-	nextByte  uint8
+	state
+}
+
+type state struct {
+	DMA      uint8 // 0xFF46
+	Active   bool
+	NextByte uint8
+
+	// Source page of the running transfer, a restart only replaces it once started
+	Source uint8
+	// M-cycles until the requested transfer starts, 0 when none is requested
+	StartDelay int
+	// Byte transferred during the current M-cycle
+	Value uint8
 }
 
 func (d *DMA) Init(bus Bus, ppu PPU) {
 	d.bus = bus
 	d.ppu = ppu
+	d.DMA = 0
+	d.Active = false
+	d.NextByte = 0
+	d.Source = 0
+	d.StartDelay = 0
+	d.Value = 0
 }
 
 func (d *DMA) Step(cycles int) {
-	if !d.dmaActive {
+	for range cycles / 4 {
+		d.tick()
+	}
+}
+
+// tick advances the DMA by an M-cycle, transferring a byte when active
+func (d *DMA) tick() {
+	if d.Active && d.NextByte == DMA_BYTES {
+		d.Active = false
+		d.ppu.ToggleDMAActive(false)
+	}
+
+	if d.StartDelay > 0 {
+		d.StartDelay--
+
+		if d.StartDelay == 0 {
+			d.Source = d.DMA
+			d.NextByte = 0
+			d.Active = true
+			d.ppu.ToggleDMAActive(true)
+		}
+	}
+
+	if !d.Active || d.NextByte == DMA_BYTES {
 		return
 	}
 
-	for range cycles / 4 {
-		srcAddr := uint16(d.dma)<<8 | uint16(d.nextByte)
-		destAddr := 0xFE00 | uint16(d.nextByte)
+	srcAddr := uint16(d.Source)<<8 | uint16(d.NextByte)
+	destAddr := OAM_START | uint16(d.NextByte)
 
-		d.ppu.WriteOAM(destAddr, d.bus.Read(srcAddr))
-		d.nextByte++
+	// Sources past WRAM read it again instead of echo RAM, OAM or IO
+	if srcAddr >= ECHO_START {
+		srcAddr -= ECHO_START - WRAM_START
+	}
 
-		if d.nextByte == DMA_BYTES {
-			d.nextByte = 0
-			d.ppu.ToggleDMAActive(false)
-			d.dmaActive = false
+	d.Value = d.bus.ReadDMA(srcAddr)
+	d.ppu.WriteOAM(destAddr, d.Value)
+	d.NextByte++
+}
 
-			return
-		}
+// Conflict returns the byte being transferred when the CPU reads the bus the DMA is using, OAM and the
+// internal memory past it are handled elsewhere
+func (d *DMA) Conflict(addr uint16) (uint8, bool) {
+	if !d.Active || addr >= OAM_START {
+		return 0, false
+	}
+
+	if isVRAM(addr) != isVRAM(uint16(d.Source)<<8) {
+		return 0, false
 	}
+
+	return d.Value, true
+}
+
+// isVRAM reports whether the address is on the video bus rather than the external one
+func isVRAM(addr uint16) bool {
+	return addr >= VRAM_START && addr <= VRAM_END
 }
 
 func (d *DMA) Read(addr uint16) uint8 {
 	switch addr {
 	case DMA_ADDR:
-		return d.dma
+		return d.DMA
 	default:
 		panic(fmt.Errorf("unsupported read for dma: %x", addr))
 	}
@@ -66,10 +134,45 @@ func (d *DMA) Read(addr uint16) uint8 {
 func (d *DMA) Write(addr uint16, value uint8) {
 	switch addr {
 	case DMA_ADDR:
-		d.dma = value
-		d.dmaActive = true
-		d.ppu.ToggleDMAActive(d.dmaActive)
+		// A running transfer keeps going until the new one starts
+		d.DMA = value
+		d.StartDelay = START_DELAY
 	default:
 		panic(fmt.Errorf("unsupported write for dma: %x", addr))
 	}
 }
+
+func (d *DMA) Peek(addr uint16) uint8 {
+	return d.Read(addr)
+}
+
+// Poke sets the source register without starting a transfer
+func (d *DMA) Poke(addr uint16, value uint8) {
+	switch addr {
+	case DMA_ADDR:
+		d.DMA = value
+	default:
+		panic(fmt.Errorf("unsupported poke for dma: %x", addr))
+	}
+}
+
+func (d *DMA) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode DMA state: %w", err)
+	}
+
+	d.state = st
+
+	return nil
+}
+
+func (d *DMA) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(d.state)
+
+	lib.Assert(err == nil, "failed to encode DMA state: %v", err)
+}
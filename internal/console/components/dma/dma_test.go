@@ -0,0 +1,107 @@
+package dma
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testBus returns the low byte of every address
+type testBus struct {
+	reads []uint16
+}
+
+func (b *testBus) ReadDMA(addr uint16) uint8 {
+	b.reads = append(b.reads, addr)
+
+	return uint8(addr)
+}
+
+type testPPU struct {
+	oam    [DMA_BYTES]uint8
+	active bool
+}
+
+func (p *testPPU) WriteOAM(addr uint16, value uint8) {
+	p.oam[addr-OAM_START] = value
+}
+
+func (p *testPPU) ToggleDMAActive(active bool) {
+	p.active = active
+}
+
+func newDMA() (*DMA, *testBus, *testPPU) {
+	bus := &testBus{}
+	ppu := &testPPU{}
+	d := &DMA{}
+	d.Init(bus, ppu)
+
+	return d, bus, ppu
+}
+
+func Test_Start_Delay(t *testing.T) {
+	d, bus, ppu := newDMA()
+
+	d.Write(DMA_ADDR, 0xC0)
+	d.Step(4)
+
+	assert.False(t, ppu.active, "setup M-cycle")
+	assert.Empty(t, bus.reads)
+
+	d.Step(4)
+
+	assert.True(t, ppu.active)
+	assert.Equal(t, []uint16{0xC000}, bus.reads)
+
+	d.Step(4 * (DMA_BYTES - 1))
+
+	assert.True(t, ppu.active, "last byte")
+
+	d.Step(4)
+
+	assert.False(t, ppu.active)
+	assert.Len(t, bus.reads, DMA_BYTES)
+	assert.Equal(t, uint8(DMA_BYTES-1), ppu.oam[DMA_BYTES-1])
+}
+
+func Test_Restart(t *testing.T) {
+	d, bus, ppu := newDMA()
+
+	d.Write(DMA_ADDR, 0xC0)
+	d.Step(4 * 10)
+	d.Write(DMA_ADDR, 0xD0)
+	d.Step(4)
+
+	assert.Equal(t, uint16(0xC009), bus.reads[len(bus.reads)-1], "the running transfer continues during the setup")
+
+	d.Step(4)
+
+	assert.True(t, ppu.active)
+	assert.Equal(t, uint16(0xD000), bus.reads[len(bus.reads)-1])
+}
+
+func Test_Source_Past_WRAM(t *testing.T) {
+	d, bus, _ := newDMA()
+
+	d.Write(DMA_ADDR, 0xFE)
+	d.Step(4 * START_DELAY)
+
+	assert.Equal(t, []uint16{0xDE00}, bus.reads)
+}
+
+func Test_Conflict(t *testing.T) {
+	d, _, _ := newDMA()
+
+	d.Write(DMA_ADDR, 0xC0)
+	d.Step(4 * (START_DELAY + 2))
+
+	value, ok := d.Conflict(0x0150)
+	assert.True(t, ok, "ROM is on the external bus")
+	assert.Equal(t, uint8(0x02), value)
+
+	_, ok = d.Conflict(0x8000)
+	assert.False(t, ok, "VRAM is on the video bus")
+
+	_, ok = d.Conflict(0xFF80)
+	assert.False(t, ok, "HRAM is internal")
+}
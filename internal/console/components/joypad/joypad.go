@@ -1,7 +1,11 @@
 package joypad
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+
+	"github.com/cterence/gbgo/internal/lib"
 )
 
 const (
@@ -14,8 +18,10 @@ type CPU interface {
 }
 
 type Joypad struct {
-	cpu    CPU
-	joypad uint8
+	cpu CPU
+
+	// Buttons are input, only the selected groups are saved
+	state
 
 	a       bool
 	b       bool
@@ -27,17 +33,21 @@ type Joypad struct {
 	selectB bool
 }
 
+type state struct {
+	JOYP uint8 // 0xFF00
+}
+
 func (j *Joypad) Init(cpu CPU) {
 	j.cpu = cpu
-	j.joypad = 0xCF
+	j.JOYP = 0xCF
 }
 
 func (j *Joypad) Read(addr uint16) uint8 {
 	switch addr {
 	case JOYPAD:
-		result := uint8(0xCF) | j.joypad
+		result := uint8(0xCF) | j.JOYP
 
-		if j.joypad&0x10 == 0 {
+		if j.JOYP&0x10 == 0 {
 			if j.right {
 				result &^= 0x1
 			}
@@ -55,7 +65,7 @@ func (j *Joypad) Read(addr uint16) uint8 {
 			}
 		}
 
-		if j.joypad&0x20 == 0 {
+		if j.JOYP&0x20 == 0 {
 			if j.a {
 				result &^= 0x1
 			}
@@ -82,12 +92,26 @@ func (j *Joypad) Read(addr uint16) uint8 {
 func (j *Joypad) Write(addr uint16, value uint8) {
 	switch addr {
 	case JOYPAD:
-		j.joypad = value & 0x30
+		j.JOYP = value & 0x30
 	default:
 		panic(fmt.Errorf("unsupported write for joypad: %x", addr))
 	}
 }
 
+// Peek reads like Read, the joypad register has no side effects
+func (j *Joypad) Peek(addr uint16) uint8 {
+	return j.Read(addr)
+}
+
+func (j *Joypad) Poke(addr uint16, value uint8) {
+	j.Write(addr, value)
+}
+
+// Pressed reports whether a button of a selected group is held, which wakes the console from STOP
+func (j *Joypad) Pressed() bool {
+	return j.Read(JOYPAD)&0x0F != 0x0F
+}
+
 func (j *Joypad) UpdateButtons(a, b, right, left, up, down, selectB, start bool) {
 	if (a && !j.a) || (b && !j.b) || (right && !j.right) || (up && !j.up) || (down && !j.down) || (left && !j.left) || (start && !j.start) || (selectB && !j.selectB) {
 		j.cpu.RequestInterrupt(INTERRUPT_CODE)
@@ -102,3 +126,24 @@ func (j *Joypad) UpdateButtons(a, b, right, left, up, down, selectB, start bool)
 	j.start = start
 	j.selectB = selectB
 }
+
+func (j *Joypad) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode joypad state: %w", err)
+	}
+
+	j.state = st
+
+	return nil
+}
+
+func (j *Joypad) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(j.state)
+
+	lib.Assert(err == nil, "failed to encode joypad state: %v", err)
+}
@@ -54,11 +54,27 @@ func (m *Memory) Write(addr uint16, value uint8) {
 	}
 }
 
-func (m *Memory) Load(buf *bytes.Reader) {
-	enc := gob.NewDecoder(buf)
-	err := enc.Decode(&m.state)
+// Peek reads like Read, WRAM and HRAM accesses have no side effects
+func (m *Memory) Peek(addr uint16) uint8 {
+	return m.Read(addr)
+}
+
+func (m *Memory) Poke(addr uint16, value uint8) {
+	m.Write(addr, value)
+}
+
+func (m *Memory) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode memory state: %w", err)
+	}
+
+	m.state = st
 
-	lib.Assert(err == nil, "failed to decode state: %v", err)
+	return nil
 }
 
 func (m *Memory) Save(buf *bytes.Buffer) {
@@ -29,12 +29,6 @@ func (p *PPU) fetchBGWPixels() {
 
 	tileMapSelector := lib.BToU8(p.BGTileMap)
 
-	// Check if we should be fetching a window pixel
-	if !p.WindowTriggered && p.WindowEnabled && p.WX <= 166 && p.LY >= p.WY && p.FetchedX+7 >= p.WX {
-		p.WindowTriggered = true
-		p.BGScanlineContainedWindow = true
-	}
-
 	if p.WindowTriggered {
 		tileMapSelector = lib.BToU8(p.WindowTileMap)
 		windowX := (p.FetchedX + 7 - p.WX) / 8
@@ -157,7 +151,6 @@ func (p *PPU) fetchObjPixels() {
 func (p *PPU) pushPixelToLCD() {
 	if !p.WindowTriggered && p.DiscardedPixels < p.SCX%8 {
 		p.BackgroundFIFO.Pop()
-		p.ObjectFIFO.Pop()
 		p.DiscardedPixels++
 
 		return
@@ -15,6 +15,17 @@ const (
 	LINES_PER_FRAME  = 154
 	FRAMEBUFFER_SIZE = 3
 
+	// Mode 3 lasts at least 172 dots: 12 for the first tile fetches, then a pixel per dot
+	MODE_3_START_DOTS = 12
+	WINDOW_FETCH_DOTS = 6
+	OBJ_FETCH_DOTS    = 6
+	// Objects at X=0 always wait for a whole BG fetch
+	OBJ_X0_PENALTY = 11
+	// LY reads 153 only at the start of the last line, then 0
+	LY_153_DOTS = 4
+	// The first line after enabling the LCD is shorter
+	FIRST_LINE_SKIPPED_DOTS = 4
+
 	VBLANK_INTERRUPT_CODE = 0x1
 	STAT_INTERRUPT_CODE   = 0x2
 
@@ -41,6 +52,10 @@ const (
 	OAM_END   = 0xFE9F
 	OAM_SIZE  = OAM_END - OAM_START + 1
 
+	// OAM is read by rows of 4 words during OAM scan, a row per M-cycle
+	OAM_ROW_SIZE  = 8
+	OAM_ROWS      = OAM_SIZE / OAM_ROW_SIZE
+	OAM_BUG_END   = 0xFEFF
 	TILE_MAP_SIZE = 0x400
 
 	TILE_BLOCK_0 uint16 = 0x8000
@@ -84,6 +99,19 @@ type PPU struct {
 	bus Bus
 	cpu CPU
 	state
+
+	stubLY   bool
+	stubLYTo uint8
+}
+
+type Option func(*PPU)
+
+// WithStubbedLY makes LY reads always return value, which is required by trace comparison tools
+func WithStubbedLY(value uint8) Option {
+	return func(p *PPU) {
+		p.stubLY = true
+		p.stubLYTo = value
+	}
 }
 
 type state struct {
@@ -112,6 +140,17 @@ type state struct {
 	WindowTriggered           bool
 	BGScanlineContainedWindow bool
 
+	// Dots during which the pixel pipeline waits for a fetch
+	Stall int
+	// BG or window tile of the last object that waited for its fetch, objects on the same tile don't wait again
+	ObjPenaltyTile    int
+	ObjPenaltyTileSet bool
+
+	// The first line after enabling the LCD reads as mode 0 instead of OAM scan
+	FirstLine bool
+	// Combined STAT interrupt sources, the interrupt is requested when it rises
+	STATLine bool
+
 	// LDCD
 	PPUEnabled    bool
 	WindowTileMap bool
@@ -145,7 +184,11 @@ type state struct {
 	FrameReady bool
 }
 
-func (p *PPU) Init(bus Bus, cpu CPU) {
+func (p *PPU) Init(bus Bus, cpu CPU, options ...Option) {
+	for _, o := range options {
+		o(p)
+	}
+
 	p.bus = bus
 	p.cpu = cpu
 	p.LineCycles = 0
@@ -168,6 +211,11 @@ func (p *PPU) Init(bus Bus, cpu CPU) {
 	p.FetchedObjects = 0
 	p.WindowTriggered = false
 	p.BGScanlineContainedWindow = false
+	p.Stall = 0
+	p.ObjPenaltyTile = 0
+	p.ObjPenaltyTileSet = false
+	p.FirstLine = false
+	p.STATLine = false
 	p.PPUEnabled = false
 	p.WindowTileMap = false
 	p.WindowEnabled = false
@@ -197,13 +245,13 @@ func (p *PPU) Init(bus Bus, cpu CPU) {
 func (p *PPU) Read(addr uint16) uint8 {
 	switch {
 	case addr >= VRAM_START && addr <= VRAM_END:
-		if p.PPUMode == DRAW {
+		if p.vramLocked() {
 			return 0xFF
 		}
 
 		return p.VRAM[addr-VRAM_START]
 	case addr >= OAM_START && addr <= OAM_END:
-		if p.DMAActive || (p.PPUMode == OAM_SCAN || p.PPUMode == DRAW) {
+		if p.oamLocked() {
 			return 0xFF
 		}
 
@@ -219,6 +267,10 @@ func (p *PPU) Read(addr uint16) uint8 {
 		case SCX:
 			return p.SCX
 		case LY:
+			if p.stubLY {
+				return p.stubLYTo
+			}
+
 			return p.LY
 		case LYC:
 			return p.LYC
@@ -241,11 +293,11 @@ func (p *PPU) Read(addr uint16) uint8 {
 func (p *PPU) Write(addr uint16, value uint8) {
 	switch {
 	case addr >= VRAM_START && addr <= VRAM_END:
-		if p.PPUMode != DRAW {
+		if !p.vramLocked() {
 			p.VRAM[addr-VRAM_START] = value
 		}
 	case addr >= OAM_START && addr <= OAM_END:
-		if !p.DMAActive && p.PPUMode != OAM_SCAN && p.PPUMode != DRAW {
+		if !p.oamLocked() {
 			p.OAM[addr-OAM_START] = value
 		}
 	default:
@@ -262,6 +314,10 @@ func (p *PPU) Write(addr uint16, value uint8) {
 			p.LY = value
 		case LYC:
 			p.LYC = value
+
+			if p.PPUEnabled {
+				p.updateLYC()
+			}
 		case BGP:
 			p.BGP = value
 		case OBP0:
@@ -278,30 +334,159 @@ func (p *PPU) Write(addr uint16, value uint8) {
 	}
 }
 
+// WriteOAM writes a byte transferred by the OAM DMA, which has access to OAM whatever the PPU mode
 func (p *PPU) WriteOAM(addr uint16, value uint8) {
 	p.OAM[addr-OAM_START] = value
 }
 
+// Peek reads VRAM and OAM without the CPU lockouts, for debugging tools
+func (p *PPU) Peek(addr uint16) uint8 {
+	switch {
+	case addr >= VRAM_START && addr <= VRAM_END:
+		return p.VRAM[addr-VRAM_START]
+	case addr >= OAM_START && addr <= OAM_END:
+		return p.OAM[addr-OAM_START]
+	default:
+		return p.Read(addr)
+	}
+}
+
+// Poke writes VRAM and OAM without the CPU lockouts, for debugging tools.
+// Registers are set without turning the LCD on or off nor updating the STAT interrupt line.
+func (p *PPU) Poke(addr uint16, value uint8) {
+	switch {
+	case addr >= VRAM_START && addr <= VRAM_END:
+		p.VRAM[addr-VRAM_START] = value
+	case addr >= OAM_START && addr <= OAM_END:
+		p.OAM[addr-OAM_START] = value
+	case addr == LCDC:
+		p.decodeLCDC(value)
+	case addr == STAT:
+		p.decodeSTAT(value)
+	case addr == LYC:
+		p.LYC = value
+	default:
+		p.Write(addr, value)
+	}
+}
+
+// vramLocked reports whether the PPU is fetching from VRAM, the CPU then reads 0xFF and its writes are ignored
+func (p *PPU) vramLocked() bool {
+	return p.PPUEnabled && p.PPUMode == DRAW
+}
+
+// oamLocked reports whether the PPU or the OAM DMA is using OAM, the CPU then reads 0xFF and its writes are ignored
+func (p *PPU) oamLocked() bool {
+	return p.DMAActive || (p.PPUEnabled && (p.PPUMode == OAM_SCAN || p.PPUMode == DRAW))
+}
+
 func (p *PPU) ToggleDMAActive(active bool) {
 	p.DMAActive = active
 }
 
+// OAMScanRow returns the OAM row the PPU is reading, when it is scanning OAM
+func (p *PPU) OAMScanRow() (int, bool) {
+	if !p.PPUEnabled || p.PPUMode != OAM_SCAN {
+		return 0, false
+	}
+
+	return min(p.LineCycles/4, OAM_ROWS-1), true
+}
+
+// CorruptOnWrite applies the OAM bug of a write or a 16-bit increment to the row being scanned,
+// see https://gbdev.io/pandocs/OAM_Corruption_Bug.html
+func (p *PPU) CorruptOnWrite(addr uint16) {
+	row, ok := p.oamBugRow(addr)
+	if !ok {
+		return
+	}
+
+	a := p.oamWord(row, 0)
+	b := p.oamWord(row-1, 0)
+	c := p.oamWord(row-1, 2)
+
+	p.setOAMWord(row, 0, ((a^c)&(b^c))^c)
+	p.copyOAMRow(row-1, row, 2)
+}
+
+func (p *PPU) CorruptOnRead(addr uint16) {
+	row, ok := p.oamBugRow(addr)
+	if !ok {
+		return
+	}
+
+	a := p.oamWord(row, 0)
+	b := p.oamWord(row-1, 0)
+	c := p.oamWord(row-1, 2)
+
+	p.setOAMWord(row, 0, b|(a&c))
+	p.copyOAMRow(row-1, row, 2)
+}
+
+// CorruptOnReadIncrease applies the OAM bug of a read and a 16-bit increment in the same M-cycle
+func (p *PPU) CorruptOnReadIncrease(addr uint16) {
+	row, ok := p.oamBugRow(addr)
+	if !ok {
+		return
+	}
+
+	if row >= 4 && row < OAM_ROWS-1 {
+		a := p.oamWord(row-2, 0)
+		b := p.oamWord(row-1, 0)
+		c := p.oamWord(row, 0)
+		d := p.oamWord(row-1, 2)
+
+		p.setOAMWord(row-1, 0, (b&(a|c|d))|(a&c&d))
+		p.copyOAMRow(row-1, row-2, 0)
+		p.copyOAMRow(row-1, row, 0)
+	}
+
+	p.CorruptOnRead(addr)
+}
+
+// oamBugRow returns the row corrupted by an access to addr, the first row never is
+func (p *PPU) oamBugRow(addr uint16) (int, bool) {
+	if addr < OAM_START || addr > OAM_BUG_END {
+		return 0, false
+	}
+
+	row, ok := p.OAMScanRow()
+
+	return row, ok && row > 0
+}
+
+func (p *PPU) oamWord(row, word int) uint16 {
+	i := row*OAM_ROW_SIZE + word*2
+
+	return uint16(p.OAM[i]) | uint16(p.OAM[i+1])<<8
+}
+
+func (p *PPU) setOAMWord(row, word int, value uint16) {
+	i := row*OAM_ROW_SIZE + word*2
+
+	p.OAM[i] = uint8(value)
+	p.OAM[i+1] = uint8(value >> 8)
+}
+
+// copyOAMRow copies a row from its byte offset onwards
+func (p *PPU) copyOAMRow(src, dst, offset int) {
+	copy(p.OAM[dst*OAM_ROW_SIZE+offset:(dst+1)*OAM_ROW_SIZE], p.OAM[src*OAM_ROW_SIZE+offset:(src+1)*OAM_ROW_SIZE])
+}
+
 var tileMapAreas = [2]uint16{0x9800, 0x9C00}
 
 func (p *PPU) Step(cycles int) {
 	if !p.PPUEnabled {
-		if p.LY != 0 || p.PPUMode != HBLANK {
-			p.CurrentFrameBuffer.clear()
-
-			p.LY = 0
-			p.LineCycles = 0
-			p.PPUMode = HBLANK
-		}
-
 		return
 	}
 
-	p.LineCycles += cycles
+	for range cycles {
+		p.dot()
+	}
+}
+
+func (p *PPU) dot() {
+	p.LineCycles++
 
 	switch p.PPUMode {
 	case OAM_SCAN:
@@ -310,29 +495,25 @@ func (p *PPU) Step(cycles int) {
 		}
 
 	case DRAW:
-		if p.FetchedObjects < p.ObjectCount && p.Objects[p.FetchedObjects].X <= p.PushedX+X_OFFSET {
-			p.fetchObjPixels()
-		} else {
-			p.fetchBGWPixels()
-			p.pushPixelToLCD()
-		}
+		p.drawDot()
 
 		if p.PushedX >= WIDTH {
-			p.PPUMode = HBLANK
+			p.setMode(HBLANK)
+		}
 
-			if p.HBlankInt {
-				p.cpu.RequestInterrupt(STAT_INTERRUPT_CODE)
-			}
+	case HBLANK:
+		if p.FirstLine && p.LineCycles >= OAM_CYCLES {
+			p.FirstLine = false
+			p.scanOAM()
 
 			break
 		}
 
-	case HBLANK:
 		if p.LineCycles >= CYCLES_PER_LINE {
 			p.LineCycles = 0
 			p.LY++
 
-			p.checkLYC()
+			p.updateLYC()
 
 			if p.BGScanlineContainedWindow {
 				p.WindowLineCounter++
@@ -340,9 +521,8 @@ func (p *PPU) Step(cycles int) {
 			}
 
 			if p.LY < HEIGHT {
-				p.PPUMode = OAM_SCAN
+				p.setMode(OAM_SCAN)
 			} else {
-				p.PPUMode = VBLANK
 				p.WindowLineCounter = 0
 
 				copy(p.CompletedFrame[:], p.CurrentFrameBuffer[:])
@@ -351,36 +531,100 @@ func (p *PPU) Step(cycles int) {
 				p.FrameReady = true
 
 				p.cpu.RequestInterrupt(VBLANK_INTERRUPT_CODE)
-
-				if p.VBlankInt {
-					p.cpu.RequestInterrupt(STAT_INTERRUPT_CODE)
-				}
+				p.setMode(VBLANK)
 			}
 		}
 
 	case VBLANK:
+		if p.LY == LINES_PER_FRAME-1 && p.LineCycles == LY_153_DOTS {
+			p.LY = 0
+			p.updateLYC()
+		}
+
 		if p.LineCycles >= CYCLES_PER_LINE {
 			p.LineCycles = 0
-			p.LY++
-			p.checkLYC()
 
-			if p.LY == LINES_PER_FRAME {
-				p.PPUMode = OAM_SCAN
-				p.LY = 0
+			// LY is already 0 during line 153
+			if p.LY == 0 {
+				p.setMode(OAM_SCAN)
 
-				if p.OAMInt {
-					p.cpu.RequestInterrupt(STAT_INTERRUPT_CODE)
-				}
+				break
 			}
+
+			p.LY++
+			p.updateLYC()
 		}
 	}
 }
 
-func (p *PPU) Load(buf *bytes.Reader) {
-	enc := gob.NewDecoder(buf)
-	err := enc.Decode(&p.state)
+// drawDot runs the pixel pipeline for a dot of mode 3, whose length depends on the stalls of the fetches
+func (p *PPU) drawDot() {
+	if p.Stall > 0 {
+		p.Stall--
+		return
+	}
+
+	if p.ObjEnabled && p.FetchedObjects < p.ObjectCount && p.Objects[p.FetchedObjects].X <= p.PushedX+X_OFFSET {
+		p.Stall = p.objPenalty(p.Objects[p.FetchedObjects]) - 1
+		p.fetchObjPixels()
+
+		return
+	}
+
+	// The window restarts the fetcher on an empty FIFO
+	if !p.WindowTriggered && p.WindowEnabled && p.WX <= 166 && p.LY >= p.WY && p.PushedX+7 >= p.WX {
+		p.WindowTriggered = true
+		p.BGScanlineContainedWindow = true
+		p.BackgroundFIFO.Clear()
+		p.FetchedX = p.PushedX
+		p.Stall = WINDOW_FETCH_DOTS - 1
+
+		return
+	}
+
+	p.fetchBGWPixels()
+	p.pushPixelToLCD()
+}
+
+// objPenalty is the number of dots an object fetch takes. The first object on a BG or window tile also waits
+// for the fetch of that tile to finish, see https://gbdev.io/pandocs/Rendering.html#mode-3-length
+func (p *PPU) objPenalty(obj object) int {
+	if obj.X == 0 {
+		return OBJ_X0_PENALTY
+	}
+
+	// Position of the leftmost pixel of the object in the background or window
+	x := int(obj.X) - X_OFFSET + int(p.SCX)
+	if p.WindowTriggered {
+		x = int(obj.X) - X_OFFSET - (int(p.WX) - 7)
+	}
+
+	tile := x >> 3
+	penalty := OBJ_FETCH_DOTS
+
+	if !p.ObjPenaltyTileSet || p.ObjPenaltyTile != tile {
+		p.ObjPenaltyTile = tile
+		p.ObjPenaltyTileSet = true
+
+		// Pixels of the tile right of the object, minus 2
+		penalty += max(0, 5-(x&7))
+	}
+
+	return penalty
+}
+
+func (p *PPU) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
 
-	lib.Assert(err == nil, "failed to decode state: %v", err)
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode PPU state: %w", err)
+	}
+
+	p.state = st
+
+	return nil
 }
 
 func (p *PPU) Save(buf *bytes.Buffer) {
@@ -395,6 +639,21 @@ func (p *PPU) GetFrame() [WIDTH][HEIGHT]uint8 {
 	return p.CompletedFrame
 }
 
+// GetVRAM returns a copy of VRAM, for debugging views
+func (p *PPU) GetVRAM() [VRAM_SIZE]uint8 {
+	return p.VRAM
+}
+
+func (p *PPU) GetFrameCount() uint64 {
+	return p.Frames
+}
+
+// Blank shows a white screen, like the LCD does while the console is stopped
+func (p *PPU) Blank() {
+	p.CompletedFrame.clear()
+	p.FrameReady = true
+}
+
 func (p *PPU) IsFrameReady() bool {
 	return p.FrameReady
 }
@@ -443,8 +702,10 @@ func (p *PPU) scanOAM() {
 	p.BackgroundFIFO.Clear()
 	p.ObjectFIFO.Clear()
 	p.WindowTriggered = false
+	p.Stall = MODE_3_START_DOTS
+	p.ObjPenaltyTileSet = false
 
-	p.PPUMode = DRAW
+	p.setMode(DRAW)
 }
 
 func (p *PPU) readLCDC() uint8 {
@@ -463,6 +724,31 @@ func (p *PPU) readLCDC() uint8 {
 }
 
 func (p *PPU) setLCDC(value uint8) {
+	wasEnabled := p.PPUEnabled
+
+	p.decodeLCDC(value)
+
+	switch {
+	case wasEnabled && !p.PPUEnabled:
+		// LY and the mode read 0 while the LCD is off
+		p.CurrentFrameBuffer.clear()
+
+		p.LY = 0
+		p.LineCycles = 0
+		p.PPUMode = HBLANK
+		p.STATLine = false
+	case !wasEnabled && p.PPUEnabled:
+		// The first line skips OAM scan, its mode reads 0 until drawing starts and it is 4 dots shorter
+		p.LY = 0
+		p.LineCycles = FIRST_LINE_SKIPPED_DOTS
+		p.PPUMode = HBLANK
+		p.FirstLine = true
+
+		p.updateLYC()
+	}
+}
+
+func (p *PPU) decodeLCDC(value uint8) {
 	p.PPUEnabled = value&0x80 != 0
 	p.WindowTileMap = value&0x40 != 0
 	p.WindowEnabled = value&0x20 != 0
@@ -487,18 +773,43 @@ func (p *PPU) readSTAT() uint8 {
 }
 
 func (p *PPU) setSTAT(value uint8) {
+	p.decodeSTAT(value)
+
+	p.updateSTATLine()
+}
+
+func (p *PPU) decodeSTAT(value uint8) {
 	p.LYCInt = value&0x40 != 0
 	p.OAMInt = value&0x20 != 0
 	p.VBlankInt = value&0x10 != 0
 	p.HBlankInt = value&0x08 != 0
 }
 
-func (p *PPU) checkLYC() {
+func (p *PPU) setMode(mode ppuMode) {
+	p.PPUMode = mode
+
+	p.updateSTATLine()
+}
+
+func (p *PPU) updateLYC() {
 	p.LYCEqLy = p.LY == p.LYC
 
-	if p.LYCEqLy && p.LYCInt {
+	p.updateSTATLine()
+}
+
+// updateSTATLine requests the STAT interrupt when any enabled source becomes active while none was,
+// so that sources active back to back, like LYC and mode 0, only trigger once
+func (p *PPU) updateSTATLine() {
+	line := p.PPUEnabled && (p.LYCInt && p.LYCEqLy ||
+		p.HBlankInt && p.PPUMode == HBLANK ||
+		p.VBlankInt && p.PPUMode == VBLANK ||
+		p.OAMInt && p.PPUMode == OAM_SCAN)
+
+	if line && !p.STATLine {
 		p.cpu.RequestInterrupt(STAT_INTERRUPT_CODE)
 	}
+
+	p.STATLine = line
 }
 
 func (f *frameBuffer) clear() {
@@ -0,0 +1,438 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// LCD, BG and objects enabled
+	LCDC_ON = 0x93
+	// LCD, BG, objects, and window enabled
+	LCDC_WINDOW = 0xB3
+
+	STAT_HBLANK_INT = 0x08
+	STAT_LYC_INT    = 0x40
+)
+
+type testCPU struct {
+	statInterrupts int
+}
+
+func (c *testCPU) RequestInterrupt(code uint8) {
+	if code == STAT_INTERRUPT_CODE {
+		c.statInterrupts++
+	}
+}
+
+func newPPU() (*PPU, *testCPU) {
+	cpu := &testCPU{}
+	p := &PPU{}
+	p.Init(nil, cpu)
+
+	return p, cpu
+}
+
+// stepUntil steps dot by dot until the PPU reaches the given line and mode
+func stepUntil(p *PPU, ly uint8, mode ppuMode) {
+	for p.LY != ly || p.PPUMode != mode {
+		p.Step(1)
+	}
+}
+
+// mode3Length measures the length of mode 3 on line 1, the first line after enabling the LCD is shorter
+func mode3Length(p *PPU) int {
+	stepUntil(p, 1, DRAW)
+
+	dots := 0
+	for p.PPUMode == DRAW {
+		p.Step(1)
+		dots++
+	}
+
+	return dots
+}
+
+func addObject(p *PPU, i int, x uint8) {
+	p.OAM[i*4] = Y_OFFSET
+	p.OAM[i*4+1] = x
+}
+
+func Test_Mode_3_Length(t *testing.T) {
+	tests := []struct {
+		name    string
+		scx     uint8
+		wx      uint8
+		objects []uint8
+		want    int
+	}{
+		{"minimum", 0, 0xFF, nil, 172},
+		{"SCX fine scroll", 3, 0xFF, nil, 175},
+		{"window", 0, 7 + 80, nil, 178},
+		{"object at X=0", 0, 0xFF, []uint8{0}, 183},
+		{"object aligned with a BG tile", 0, 0xFF, []uint8{8}, 183},
+		{"object at the end of a BG tile", 0, 0xFF, []uint8{13}, 178},
+		{"objects on the same BG tile", 0, 0xFF, []uint8{8, 10}, 189},
+		{"object shifted by SCX", 5, 0xFF, []uint8{8}, 177 + 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newPPU()
+
+			for i, x := range tt.objects {
+				addObject(p, i, x)
+			}
+
+			p.Write(SCX, tt.scx)
+			p.Write(WX, tt.wx)
+			p.Write(LCDC, LCDC_WINDOW)
+
+			assert.Equal(t, tt.want, mode3Length(p))
+		})
+	}
+}
+
+func Test_Line_Length(t *testing.T) {
+	p, _ := newPPU()
+	p.Write(LCDC, LCDC_ON)
+
+	stepUntil(p, 1, OAM_SCAN)
+	p.Step(CYCLES_PER_LINE)
+
+	assert.Equal(t, uint8(2), p.LY)
+	assert.Equal(t, OAM_SCAN, p.PPUMode)
+}
+
+func Test_First_Line_After_Enable(t *testing.T) {
+	p, _ := newPPU()
+	p.Write(LCDC, LCDC_ON)
+
+	p.Step(OAM_CYCLES - FIRST_LINE_SKIPPED_DOTS - 1)
+	assert.Equal(t, HBLANK, p.PPUMode, "no OAM scan")
+
+	p.Step(1)
+	assert.Equal(t, DRAW, p.PPUMode)
+
+	p.Step(CYCLES_PER_LINE - OAM_CYCLES - 1)
+	assert.Equal(t, uint8(0), p.LY)
+
+	p.Step(1)
+	assert.Equal(t, uint8(1), p.LY, "the first line is shorter")
+}
+
+func Test_STAT_Line_Blocks_Back_To_Back_Sources(t *testing.T) {
+	p, cpu := newPPU()
+	p.Write(LYC, 1)
+	p.Write(STAT, STAT_HBLANK_INT|STAT_LYC_INT)
+	p.Write(LCDC, LCDC_ON)
+
+	stepUntil(p, 0, DRAW)
+	cpu.statInterrupts = 0
+
+	// Mode 0 of line 0 then LY=LYC on line 1 keep the line high
+	stepUntil(p, 1, DRAW)
+	assert.Equal(t, 1, cpu.statInterrupts)
+
+	// Mode 0 of line 1 while LY=LYC
+	stepUntil(p, 1, HBLANK)
+	assert.Equal(t, 1, cpu.statInterrupts)
+}
+
+func Test_LYC_0_On_Line_153(t *testing.T) {
+	p, cpu := newPPU()
+	p.Write(LCDC, LCDC_ON)
+	p.Write(STAT, STAT_LYC_INT)
+
+	stepUntil(p, LINES_PER_FRAME-1, VBLANK)
+	cpu.statInterrupts = 0
+
+	p.Step(LY_153_DOTS)
+
+	assert.Equal(t, uint8(0), p.LY)
+	assert.Equal(t, VBLANK, p.PPUMode, "still line 153")
+	assert.Equal(t, 1, cpu.statInterrupts)
+
+	p.Step(CYCLES_PER_LINE - LY_153_DOTS)
+
+	assert.Equal(t, OAM_SCAN, p.PPUMode)
+	assert.Equal(t, uint8(0), p.LY)
+	assert.Equal(t, 1, cpu.statInterrupts, "no second interrupt on line 0")
+}
+
+func Test_LCD_Off(t *testing.T) {
+	p, _ := newPPU()
+	p.Write(LCDC, LCDC_ON)
+
+	stepUntil(p, 10, DRAW)
+	p.Write(LCDC, 0)
+
+	assert.Equal(t, uint8(0), p.Read(LY))
+	assert.Equal(t, uint8(0), p.Read(STAT)&0x3)
+}
+
+const (
+	// Objects use color 3 of OBP0 or color 1 of OBP1, the background color 0
+	OBJ_TILE       = 1
+	OBP0_SHADE     = 3
+	OBP1_SHADE     = 1
+	IDENTITY_SHADE = 0xE4
+
+	OBJ_LINE = 1
+	OBJ_Y    = Y_OFFSET + OBJ_LINE
+)
+
+// renderObjects draws line OBJ_LINE with solid objects, those with DMGPalette set use OBP1
+func renderObjects(t *testing.T, scx uint8, objects []object) [WIDTH]uint8 {
+	t.Helper()
+
+	p, _ := newPPU()
+
+	// Color index 3 on every pixel of the object tile
+	for i := range TILE_BYTE_SIZE {
+		p.VRAM[OBJ_TILE*TILE_BYTE_SIZE+i] = 0xFF
+	}
+
+	for i, obj := range objects {
+		attrs := uint8(0)
+		if obj.DMGPalette {
+			attrs |= 0x10
+		}
+
+		p.OAM[i*4] = OBJ_Y
+		p.OAM[i*4+1] = obj.X
+		p.OAM[i*4+2] = OBJ_TILE
+		p.OAM[i*4+3] = attrs
+	}
+
+	p.Write(BGP, IDENTITY_SHADE)
+	p.Write(OBP0, IDENTITY_SHADE)
+	p.Write(OBP1, 0x44)
+	p.Write(SCX, scx)
+	p.Write(LCDC, LCDC_ON)
+
+	stepUntil(p, OBJ_LINE, HBLANK)
+
+	var line [WIDTH]uint8
+	for x := range WIDTH {
+		line[x] = p.CurrentFrameBuffer[x][OBJ_LINE]
+	}
+
+	return line
+}
+
+// expectLine builds a line from spans of an object X and shade, earlier spans have priority
+func expectLine(spans ...[2]int) [WIDTH]uint8 {
+	var line [WIDTH]uint8
+
+	for _, span := range spans {
+		for x := span[0] - X_OFFSET; x < span[0]; x++ {
+			if x >= 0 && x < WIDTH && line[x] == 0 {
+				line[x] = uint8(span[1])
+			}
+		}
+	}
+
+	return line
+}
+
+func Test_Left_Edge_Objects(t *testing.T) {
+	for x := uint8(0); x <= X_OFFSET; x++ {
+		for _, scx := range []uint8{0, 3} {
+			line := renderObjects(t, scx, []object{{X: x}})
+
+			assert.Equal(t, expectLine([2]int{int(x), OBP0_SHADE}), line, "object at X=%d with SCX=%d", x, scx)
+		}
+	}
+}
+
+func Test_Object_Priority(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []object
+		want    [WIDTH]uint8
+	}{
+		{
+			"same X, first in OAM wins",
+			[]object{{X: 20, DMGPalette: true}, {X: 20}},
+			expectLine([2]int{20, OBP1_SHADE}),
+		},
+		{
+			"lower X wins",
+			[]object{{X: 24, DMGPalette: true}, {X: 20}},
+			expectLine([2]int{20, OBP0_SHADE}, [2]int{24, OBP1_SHADE}),
+		},
+		{
+			"lower X wins on the left edge",
+			[]object{{X: 6, DMGPalette: true}, {X: 3}},
+			expectLine([2]int{3, OBP0_SHADE}, [2]int{6, OBP1_SHADE}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, renderObjects(t, 0, tt.objects))
+		})
+	}
+}
+
+// oamBugPPU returns a PPU scanning the given OAM row, with each row filled with the given words
+func oamBugPPU(row int, words map[int][4]uint16) *PPU {
+	p, _ := newPPU()
+	p.Write(LCDC, LCDC_ON)
+
+	stepUntil(p, 1, OAM_SCAN)
+	p.Step(row * 4)
+
+	for r, w := range words {
+		for i, word := range w {
+			p.setOAMWord(r, i, word)
+		}
+	}
+
+	return p
+}
+
+func Test_OAM_Bug(t *testing.T) {
+	rows := map[int][4]uint16{
+		3: {0x1111, 0x2222, 0x3333, 0x4444},
+		4: {0xF0F0, 0x5555, 0x0FF0, 0x6666},
+		5: {0xAAAA, 0x7777, 0xCCCC, 0x8888},
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(p *PPU, addr uint16)
+		want    map[int][4]uint16
+	}{
+		{
+			"write",
+			(*PPU).CorruptOnWrite,
+			map[int][4]uint16{
+				5: {((0xAAAA ^ 0x0FF0) & (0xF0F0 ^ 0x0FF0)) ^ 0x0FF0, 0x5555, 0x0FF0, 0x6666},
+			},
+		},
+		{
+			"read",
+			(*PPU).CorruptOnRead,
+			map[int][4]uint16{
+				5: {0xF0F0 | (0xAAAA & 0x0FF0), 0x5555, 0x0FF0, 0x6666},
+			},
+		},
+		{
+			"read increase",
+			(*PPU).CorruptOnReadIncrease,
+			// The preceding row is rebuilt from the 3 rows around it and copied over them, the read then keeps it
+			map[int][4]uint16{
+				3: {0xB0F0, 0x5555, 0x0FF0, 0x6666},
+				4: {0xB0F0, 0x5555, 0x0FF0, 0x6666},
+				5: {0xB0F0, 0x5555, 0x0FF0, 0x6666},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := oamBugPPU(5, rows)
+
+			tt.corrupt(p, OAM_START+0x10)
+
+			for r := 3; r <= 5; r++ {
+				want, ok := tt.want[r]
+				if !ok {
+					want = rows[r]
+				}
+
+				for i := range 4 {
+					assert.Equal(t, want[i], p.oamWord(r, i), "row %d word %d", r, i)
+				}
+			}
+		})
+	}
+}
+
+func Test_OAM_Bug_Outside_OAM_Scan(t *testing.T) {
+	rows := map[int][4]uint16{0: {0x1111}, 1: {0x2222}}
+
+	p := oamBugPPU(0, rows)
+	p.CorruptOnWrite(OAM_START)
+	assert.Equal(t, uint16(0x1111), p.oamWord(0, 0), "the first row is never corrupted")
+
+	p = oamBugPPU(1, rows)
+	p.CorruptOnWrite(OAM_START - 1)
+	assert.Equal(t, uint16(0x2222), p.oamWord(1, 0), "address outside of OAM")
+
+	stepUntil(p, 1, DRAW)
+	p.CorruptOnWrite(OAM_START)
+	assert.Equal(t, uint16(0x2222), p.oamWord(1, 0), "mode 3")
+}
+
+func Test_CPU_Lockouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		ly       uint8
+		mode     ppuMode
+		dma      bool
+		vramOpen bool
+		oamOpen  bool
+	}{
+		{"OAM scan", 1, OAM_SCAN, false, true, false},
+		{"drawing", 1, DRAW, false, false, false},
+		{"HBlank", 1, HBLANK, false, true, true},
+		{"VBlank", HEIGHT, VBLANK, false, true, true},
+		{"OAM DMA", 1, HBLANK, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newPPU()
+			p.VRAM[0] = 0x12
+			p.OAM[0] = 0x34
+
+			p.Write(LCDC, LCDC_ON)
+			stepUntil(p, tt.ly, tt.mode)
+			p.ToggleDMAActive(tt.dma)
+
+			p.Write(VRAM_START+1, 0x56)
+			p.Write(OAM_START+1, 0x78)
+
+			if tt.vramOpen {
+				assert.Equal(t, uint8(0x12), p.Read(VRAM_START))
+				assert.Equal(t, uint8(0x56), p.VRAM[1])
+			} else {
+				assert.Equal(t, uint8(0xFF), p.Read(VRAM_START))
+				assert.Equal(t, uint8(0x00), p.VRAM[1])
+			}
+
+			if tt.oamOpen {
+				assert.Equal(t, uint8(0x34), p.Read(OAM_START))
+				assert.Equal(t, uint8(0x78), p.OAM[1])
+			} else {
+				assert.Equal(t, uint8(0xFF), p.Read(OAM_START))
+				assert.Equal(t, uint8(0x00), p.OAM[1])
+			}
+
+			// Debugging tools and the OAM DMA are never locked out
+			p.Poke(VRAM_START+2, 0x9A)
+			p.Poke(OAM_START+2, 0xBC)
+			p.WriteOAM(OAM_START+3, 0xDE)
+
+			assert.Equal(t, uint8(0x12), p.Peek(VRAM_START))
+			assert.Equal(t, uint8(0x9A), p.Peek(VRAM_START+2))
+			assert.Equal(t, uint8(0xBC), p.Peek(OAM_START+2))
+			assert.Equal(t, uint8(0xDE), p.Peek(OAM_START+3))
+		})
+	}
+}
+
+func Test_No_Lockouts_With_LCD_Off(t *testing.T) {
+	p, _ := newPPU()
+	p.PPUMode = DRAW
+
+	p.Write(VRAM_START, 0x12)
+	p.Write(OAM_START, 0x34)
+
+	assert.Equal(t, uint8(0x12), p.Read(VRAM_START))
+	assert.Equal(t, uint8(0x34), p.Read(OAM_START))
+}
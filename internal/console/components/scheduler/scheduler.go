@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/cterence/gbgo/internal/lib"
+)
+
+// Event identifies what happens when an event is due, components register a handler for each of theirs
+type Event uint8
+
+const (
+	SERIAL_TRANSFER Event = iota
+
+	EVENT_COUNT
+)
+
+type Handler func()
+
+// Scheduler runs events at a given T-cycle, so that components don't have to check every cycle whether something happens
+type Scheduler struct {
+	handlers [EVENT_COUNT]Handler
+	state
+}
+
+type scheduled struct {
+	Event Event
+	At    uint64
+	// Breaks ties so that events scheduled for the same cycle run in scheduling order
+	Seq uint64
+}
+
+type state struct {
+	Now     uint64
+	NextSeq uint64
+	Events  events
+}
+
+// events is a min-heap ordered by cycle
+type events []scheduled
+
+func (e events) Len() int { return len(e) }
+
+func (e events) Less(i, j int) bool {
+	if e[i].At == e[j].At {
+		return e[i].Seq < e[j].Seq
+	}
+
+	return e[i].At < e[j].At
+}
+
+func (e events) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+func (e *events) Push(x any) { *e = append(*e, x.(scheduled)) }
+
+func (e *events) Pop() any {
+	old := *e
+	n := len(old)
+	x := old[n-1]
+	*e = old[:n-1]
+
+	return x
+}
+
+func (s *Scheduler) Init() {
+	s.Now = 0
+	s.NextSeq = 0
+	s.Events = events{}
+}
+
+func (s *Scheduler) Register(event Event, handler Handler) {
+	s.handlers[event] = handler
+}
+
+// Schedule runs the handler of event in the given number of T-cycles, replacing any pending occurrence of it
+func (s *Scheduler) Schedule(event Event, cycles uint64) {
+	s.Cancel(event)
+
+	heap.Push(&s.Events, scheduled{Event: event, At: s.Now + cycles, Seq: s.NextSeq})
+	s.NextSeq++
+}
+
+func (s *Scheduler) Cancel(event Event) {
+	for i, e := range s.Events {
+		if e.Event == event {
+			heap.Remove(&s.Events, i)
+			return
+		}
+	}
+}
+
+func (s *Scheduler) Pending(event Event) bool {
+	for _, e := range s.Events {
+		if e.Event == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Advance moves time forward and runs the events that are due, in order
+func (s *Scheduler) Advance(cycles int) {
+	end := s.Now + uint64(cycles)
+
+	for len(s.Events) > 0 && s.Events[0].At <= end {
+		e := heap.Pop(&s.Events).(scheduled)
+		s.Now = e.At
+
+		if handler := s.handlers[e.Event]; handler != nil {
+			handler()
+		}
+	}
+
+	s.Now = end
+}
+
+func (s *Scheduler) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode scheduler state: %w", err)
+	}
+
+	for _, e := range st.Events {
+		if e.Event >= EVENT_COUNT {
+			return fmt.Errorf("unknown scheduler event: %d", e.Event)
+		}
+	}
+
+	s.state = st
+	heap.Init(&s.Events)
+
+	return nil
+}
+
+func (s *Scheduler) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(s.state)
+
+	lib.Assert(err == nil, "failed to encode state: %v", err)
+}
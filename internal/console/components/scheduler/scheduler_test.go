@@ -0,0 +1,171 @@
+package scheduler
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newScheduler records the cycle at which each SERIAL_TRANSFER runs, the handler reschedules it every period cycles
+func newScheduler(period uint64) (*Scheduler, *[]uint64) {
+	s := &Scheduler{}
+	s.Init()
+
+	var runs []uint64
+
+	s.Register(SERIAL_TRANSFER, func() {
+		runs = append(runs, s.Now)
+
+		if period > 0 {
+			s.Schedule(SERIAL_TRANSFER, period)
+		}
+	})
+
+	return s, &runs
+}
+
+func Test_Events_Order(t *testing.T) {
+	e := &events{}
+
+	for _, ev := range []scheduled{
+		{At: 30, Seq: 0},
+		{At: 10, Seq: 1},
+		{At: 20, Seq: 2},
+		{At: 10, Seq: 3},
+		{At: 20, Seq: 4},
+		{At: 10, Seq: 5},
+	} {
+		heap.Push(e, ev)
+	}
+
+	var got []scheduled
+	for e.Len() > 0 {
+		got = append(got, heap.Pop(e).(scheduled))
+	}
+
+	assert.Equal(t, []scheduled{
+		{At: 10, Seq: 1},
+		{At: 10, Seq: 3},
+		{At: 10, Seq: 5},
+		{At: 20, Seq: 2},
+		{At: 20, Seq: 4},
+		{At: 30, Seq: 0},
+	}, got)
+}
+
+func Test_Schedule(t *testing.T) {
+	s, runs := newScheduler(0)
+
+	s.Schedule(SERIAL_TRANSFER, 8)
+	assert.True(t, s.Pending(SERIAL_TRANSFER))
+
+	s.Advance(7)
+	assert.Empty(t, *runs)
+
+	s.Advance(1)
+	assert.Equal(t, []uint64{8}, *runs)
+	assert.False(t, s.Pending(SERIAL_TRANSFER))
+}
+
+func Test_Schedule_Replaces_Pending(t *testing.T) {
+	s, runs := newScheduler(0)
+
+	s.Schedule(SERIAL_TRANSFER, 8)
+	s.Advance(4)
+	s.Schedule(SERIAL_TRANSFER, 8)
+
+	assert.Len(t, s.Events, 1)
+
+	s.Advance(20)
+	assert.Equal(t, []uint64{12}, *runs)
+}
+
+func Test_Cancel(t *testing.T) {
+	s, runs := newScheduler(0)
+
+	s.Schedule(SERIAL_TRANSFER, 8)
+	s.Cancel(SERIAL_TRANSFER)
+
+	assert.False(t, s.Pending(SERIAL_TRANSFER))
+
+	s.Advance(100)
+	assert.Empty(t, *runs)
+	assert.Equal(t, uint64(100), s.Now)
+
+	// Cancelling an event that isn't pending does nothing
+	s.Cancel(SERIAL_TRANSFER)
+}
+
+func Test_Advance_Runs_Every_Due_Event(t *testing.T) {
+	s, runs := newScheduler(10)
+
+	s.Schedule(SERIAL_TRANSFER, 10)
+	s.Advance(35)
+
+	// Handlers see the cycle they were scheduled for, events they schedule run in the same advance when due
+	assert.Equal(t, []uint64{10, 20, 30}, *runs)
+	assert.Equal(t, uint64(35), s.Now)
+	require.Len(t, s.Events, 1)
+	assert.Equal(t, uint64(40), s.Events[0].At)
+}
+
+func Test_Advance_Same_Cycle_Runs_In_Schedule_Order(t *testing.T) {
+	s := &Scheduler{}
+	s.Init()
+
+	// Push directly, Schedule keeps a single occurrence of each event
+	for _, seq := range []uint64{2, 0, 1} {
+		heap.Push(&s.Events, scheduled{Event: SERIAL_TRANSFER, At: 4, Seq: seq})
+	}
+
+	// The handler doesn't know which occurrence runs, the ones left show that they run by sequence
+	var remaining [][]uint64
+
+	s.Register(SERIAL_TRANSFER, func() {
+		var seqs []uint64
+		for _, e := range s.Events {
+			seqs = append(seqs, e.Seq)
+		}
+
+		slices.Sort(seqs)
+		remaining = append(remaining, seqs)
+	})
+
+	s.Advance(4)
+
+	assert.Equal(t, [][]uint64{{1, 2}, {2}, nil}, remaining)
+}
+
+func Test_Save_Load(t *testing.T) {
+	s, _ := newScheduler(10)
+
+	s.Schedule(SERIAL_TRANSFER, 10)
+	s.Advance(25)
+
+	buf := bytes.NewBuffer(nil)
+	s.Save(buf)
+
+	loaded, runs := newScheduler(10)
+	require.NoError(t, loaded.Load(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, s.state, loaded.state)
+
+	loaded.Advance(20)
+	assert.Equal(t, []uint64{30, 40}, *runs)
+}
+
+func Test_Load_Unknown_Event(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, gob.NewEncoder(buf).Encode(state{Events: events{{Event: EVENT_COUNT, At: 4}}}))
+
+	s, _ := newScheduler(0)
+	s.Schedule(SERIAL_TRANSFER, 8)
+
+	assert.ErrorContains(t, s.Load(bytes.NewReader(buf.Bytes())), "unknown scheduler event")
+	assert.True(t, s.Pending(SERIAL_TRANSFER))
+}
@@ -1,6 +1,15 @@
 package serial
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cterence/gbgo/internal/console/components/scheduler"
+	"github.com/cterence/gbgo/internal/lib"
+)
 
 const (
 	SB = 0xFF01
@@ -18,59 +27,73 @@ type CPU interface {
 	RequestInterrupt(code uint8)
 }
 
+type Scheduler interface {
+	Register(event scheduler.Event, handler scheduler.Handler)
+	Schedule(event scheduler.Event, cycles uint64)
+	Cancel(event scheduler.Event)
+	Pending(event scheduler.Event) bool
+}
+
 type Serial struct {
-	cpu CPU
+	cpu       CPU
+	scheduler Scheduler
 
-	cycles int
+	writer io.Writer
 
-	sb uint8
-	sc uint8
+	state
+}
 
-	print bool
+// A pending transfer is saved with the scheduler
+type state struct {
+	SB uint8 // 0xFF01
+	SC uint8 // 0xFF02
 }
 
 type Option func(*Serial)
 
 func WithPrintSerial() Option {
+	return WithWriter(os.Stdout)
+}
+
+// WithWriter writes every transferred byte to w
+func WithWriter(w io.Writer) Option {
 	return func(s *Serial) {
-		s.print = true
+		s.writer = w
 	}
 }
 
-func (s *Serial) Init(cpu CPU, options ...Option) {
+func (s *Serial) Init(cpu CPU, sched Scheduler, options ...Option) {
 	s.cpu = cpu
-	s.sb = 0
-	s.sc = 0
+	s.scheduler = sched
+	s.SB = 0
+	s.SC = 0
 
 	for _, o := range options {
 		o(s)
 	}
-}
 
-func (s *Serial) Step(cycles int) {
-	if s.sc&0x81 != 0x81 {
-		return
-	}
+	s.scheduler.Register(scheduler.SERIAL_TRANSFER, s.transferDone)
+}
 
-	s.cycles += cycles
-	if s.cycles >= SERIAL_CYCLES {
-		if s.print {
-			fmt.Print(string(s.sb))
+// transferDone shifts out the whole byte at once, no device is ever connected
+func (s *Serial) transferDone() {
+	if s.writer != nil {
+		if _, err := s.writer.Write([]uint8{s.SB}); err != nil {
+			fmt.Printf("failed to write serial output: %v\n", err)
 		}
-
-		s.sb = 0xFF
-		s.sc &= 0x7F
-		s.cycles = 0
-		s.cpu.RequestInterrupt(INTERRUPT_CODE)
 	}
+
+	s.SB = 0xFF
+	s.SC &= 0x7F
+	s.cpu.RequestInterrupt(INTERRUPT_CODE)
 }
 
 func (s *Serial) Read(addr uint16) uint8 {
 	switch addr {
 	case SB:
-		return s.sb
+		return s.SB
 	case SC:
-		return s.sc
+		return s.SC
 	default:
 		panic(fmt.Errorf("unsupported read for serial: %x", addr))
 	}
@@ -79,10 +102,54 @@ func (s *Serial) Read(addr uint16) uint8 {
 func (s *Serial) Write(addr uint16, value uint8) {
 	switch addr {
 	case SB:
-		s.sb = value
+		s.SB = value
 	case SC:
-		s.sc = value
+		s.SC = value
+
+		switch {
+		case s.SC&0x81 != 0x81:
+			s.scheduler.Cancel(scheduler.SERIAL_TRANSFER)
+		case !s.scheduler.Pending(scheduler.SERIAL_TRANSFER):
+			s.scheduler.Schedule(scheduler.SERIAL_TRANSFER, SERIAL_CYCLES)
+		}
 	default:
 		panic(fmt.Errorf("unsupported write for serial: %x", addr))
 	}
 }
+
+func (s *Serial) Peek(addr uint16) uint8 {
+	return s.Read(addr)
+}
+
+// Poke sets the registers without starting nor cancelling a transfer
+func (s *Serial) Poke(addr uint16, value uint8) {
+	switch addr {
+	case SB:
+		s.SB = value
+	case SC:
+		s.SC = value
+	default:
+		panic(fmt.Errorf("unsupported poke for serial: %x", addr))
+	}
+}
+
+func (s *Serial) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode serial state: %w", err)
+	}
+
+	s.state = st
+
+	return nil
+}
+
+func (s *Serial) Save(buf *bytes.Buffer) {
+	enc := gob.NewEncoder(buf)
+	err := enc.Encode(s.state)
+
+	lib.Assert(err == nil, "failed to encode serial state: %v", err)
+}
@@ -29,19 +29,24 @@ type Timer struct {
 }
 
 type state struct {
-	TIMACPUCycles int
+	// TIMA overflowed during the last M-cycle, it is reloaded from TMA at the next one
+	Overflowed bool
+	// TIMA was reloaded during the current M-cycle, writes to TIMA are ignored and writes to TMA go through
+	Reloaded bool
 
-	DIV  uint16 // 0xFF04
+	DIV  uint16 // 0xFF04, the upper byte of the system counter
 	TIMA uint8  // 0xFF05
 	TMA  uint8  // 0xFF06
 	TAC  uint8  // 0xFF07
 }
 
-var timaFreqs = []int{1024, 16, 64, 256}
+// System counter bit whose falling edge increments TIMA, for each TAC clock select
+var timaBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
 
 func (t *Timer) Init(cpu CPU) {
 	t.cpu = cpu
-	t.TIMACPUCycles = 0
+	t.Overflowed = false
+	t.Reloaded = false
 	t.DIV = 0
 	t.TIMA = 0
 	t.TMA = 0
@@ -49,23 +54,46 @@ func (t *Timer) Init(cpu CPU) {
 }
 
 func (t *Timer) Step(cycles int) {
-	t.DIV += uint16(cycles)
+	for range cycles / 4 {
+		t.tick()
+	}
+}
+
+// tick advances the timer by an M-cycle
+func (t *Timer) tick() {
+	t.Reloaded = false
 
-	if t.TAC&0x4 != 0x4 {
-		return
+	if t.Overflowed {
+		t.Overflowed = false
+		t.Reloaded = true
+		t.TIMA = t.TMA
+		t.cpu.RequestInterrupt(INTERRUPT_CODE)
 	}
 
-	t.TIMACPUCycles += cycles
-	timaFreq := t.TAC & 0x3
+	t.setCounter(t.DIV + 4)
+}
 
-	if t.TIMACPUCycles >= timaFreqs[timaFreq] {
-		t.TIMA++
-		t.TIMACPUCycles -= timaFreqs[timaFreq]
+// setCounter updates the system counter, TIMA increments when the selected bit falls
+func (t *Timer) setCounter(value uint16) {
+	before := t.timaInput()
+	t.DIV = value
 
-		if t.TIMA == 0 {
-			t.TIMA = t.TMA
-			t.cpu.RequestInterrupt(INTERRUPT_CODE)
-		}
+	if before && !t.timaInput() {
+		t.incrementTIMA()
+	}
+}
+
+// timaInput is the selected bit of the system counter, ANDed with the timer enable bit
+func (t *Timer) timaInput() bool {
+	return t.TAC&0x4 != 0 && t.DIV&timaBits[t.TAC&0x3] != 0
+}
+
+func (t *Timer) incrementTIMA() {
+	t.TIMA++
+
+	// TIMA reads 0 until the reload
+	if t.TIMA == 0 {
+		t.Overflowed = true
 	}
 }
 
@@ -78,7 +106,7 @@ func (t *Timer) Read(addr uint16) uint8 {
 	case TMA:
 		return t.TMA
 	case TAC:
-		return t.TAC
+		return t.TAC | 0xF8
 	default:
 		panic(fmt.Errorf("unsupported read on timer: %x", addr))
 	}
@@ -87,23 +115,67 @@ func (t *Timer) Read(addr uint16) uint8 {
 func (t *Timer) Write(addr uint16, value uint8) {
 	switch addr {
 	case DIV:
-		t.DIV = 0
+		// Resetting the counter can make the selected bit fall
+		t.setCounter(0)
 	case TIMA:
+		if t.Reloaded {
+			return
+		}
+
+		// Writing during the overflow M-cycle cancels the reload and the interrupt
 		t.TIMA = value
+		t.Overflowed = false
 	case TMA:
 		t.TMA = value
+
+		if t.Reloaded {
+			t.TIMA = value
+		}
 	case TAC:
-		t.TAC = value
+		// Disabling the timer or selecting another bit can make the input fall
+		before := t.timaInput()
+		t.TAC = value & 0x7
+
+		if before && !t.timaInput() {
+			t.incrementTIMA()
+		}
 	default:
 		panic(fmt.Errorf("unsupported write on timer: %x", addr))
 	}
 }
 
-func (t *Timer) Load(buf *bytes.Reader) {
-	enc := gob.NewDecoder(buf)
-	err := enc.Decode(&t.state)
+func (t *Timer) Peek(addr uint16) uint8 {
+	return t.Read(addr)
+}
+
+// Poke sets the registers without the falling edge and reload behaviours, DIV sets the upper byte of the counter
+func (t *Timer) Poke(addr uint16, value uint8) {
+	switch addr {
+	case DIV:
+		t.DIV = uint16(value) << 8
+	case TIMA:
+		t.TIMA = value
+	case TMA:
+		t.TMA = value
+	case TAC:
+		t.TAC = value & 0x7
+	default:
+		panic(fmt.Errorf("unsupported poke on timer: %x", addr))
+	}
+}
+
+func (t *Timer) Load(buf *bytes.Reader) error {
+	dec := gob.NewDecoder(buf)
+
+	var st state
+
+	if err := dec.Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode timer state: %w", err)
+	}
+
+	t.state = st
 
-	lib.Assert(err == nil, "failed to decode state: %v", err)
+	return nil
 }
 
 func (t *Timer) Save(buf *bytes.Buffer) {
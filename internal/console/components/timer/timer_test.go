@@ -0,0 +1,117 @@
+package timer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// Enabled, TIMA increments every 16 T-cycles
+	TAC_16 = 0x5
+	// Bit 3 of the system counter, selected by TAC_16
+	BIT_3 = 0x8
+)
+
+type testCPU struct {
+	interrupts int
+}
+
+func (c *testCPU) RequestInterrupt(code uint8) {
+	if code == INTERRUPT_CODE {
+		c.interrupts++
+	}
+}
+
+func newTimer() (*Timer, *testCPU) {
+	cpu := &testCPU{}
+	t := &Timer{}
+	t.Init(cpu)
+	t.Write(TAC, TAC_16)
+
+	return t, cpu
+}
+
+// overflow steps until TIMA overflows from FF, it then reads 0 until the next M-cycle
+func overflow(t *Timer) {
+	t.Write(TIMA, 0xFF)
+
+	for !t.Overflowed {
+		t.Step(4)
+	}
+}
+
+func Test_TIMA_Increments(t *testing.T) {
+	timer, _ := newTimer()
+
+	timer.Step(64)
+
+	assert.Equal(t, uint8(4), timer.Read(TIMA))
+	assert.Equal(t, uint8(0), timer.Read(DIV))
+
+	timer.Step(256 - 64)
+
+	assert.Equal(t, uint8(1), timer.Read(DIV))
+}
+
+func Test_DIV_Write_Falling_Edge(t *testing.T) {
+	timer, _ := newTimer()
+
+	timer.Step(BIT_3)
+	timer.Write(DIV, 0)
+
+	assert.Equal(t, uint8(1), timer.Read(TIMA))
+	assert.Equal(t, uint16(0), timer.DIV)
+}
+
+func Test_TAC_Write_Falling_Edge(t *testing.T) {
+	timer, _ := newTimer()
+
+	timer.Step(BIT_3)
+	timer.Write(TAC, 0)
+
+	assert.Equal(t, uint8(1), timer.Read(TIMA))
+	assert.Equal(t, uint8(0xF8), timer.Read(TAC))
+}
+
+func Test_TIMA_Reload_Delay(t *testing.T) {
+	timer, cpu := newTimer()
+	timer.Write(TMA, 0x42)
+
+	overflow(timer)
+
+	assert.Equal(t, uint8(0), timer.Read(TIMA))
+	assert.Equal(t, 0, cpu.interrupts)
+
+	timer.Step(4)
+
+	assert.Equal(t, uint8(0x42), timer.Read(TIMA))
+	assert.Equal(t, 1, cpu.interrupts)
+}
+
+func Test_TIMA_Write_During_Overflow(t *testing.T) {
+	timer, cpu := newTimer()
+	timer.Write(TMA, 0x42)
+
+	overflow(timer)
+	timer.Write(TIMA, 0x10)
+	timer.Step(4)
+
+	assert.Equal(t, uint8(0x10), timer.Read(TIMA))
+	assert.Equal(t, 0, cpu.interrupts)
+}
+
+func Test_Write_During_Reload(t *testing.T) {
+	timer, _ := newTimer()
+	timer.Write(TMA, 0x42)
+
+	overflow(timer)
+	timer.Step(4)
+	timer.Write(TIMA, 0x10)
+
+	assert.Equal(t, uint8(0x42), timer.Read(TIMA), "TIMA writes are ignored")
+
+	timer.Write(TMA, 0x20)
+
+	assert.Equal(t, uint8(0x20), timer.Read(TIMA), "TMA writes are copied to TIMA")
+}
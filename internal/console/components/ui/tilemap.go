@@ -0,0 +1,154 @@
+package ui
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+const (
+	LCDC = 0xFF40
+	SCY  = 0xFF42
+	SCX  = 0xFF43
+	WY   = 0xFF4A
+	WX   = 0xFF4B
+
+	LCDC_BG_TILE_MAP = 0x08
+	LCDC_TILE_DATA   = 0x10
+	LCDC_WINDOW      = 0x20
+
+	TILE_MAP_SIZE  = 32
+	TILE_MAP_PIXEL = TILE_MAP_SIZE * TILE_SIZE
+	// Tile maps are at 0x9800 and 0x9C00
+	TILE_MAP_0 = 0x1800
+	TILE_MAP_1 = 0x1C00
+	// Tile indexes are signed and relative to tile 256 unless LCDC_TILE_DATA is set
+	SIGNED_TILE_BASE = 256
+
+	WX_OFFSET = 7
+)
+
+type tileMapSource uint8
+
+const (
+	// The BG tile map selected by LCDC
+	TILE_MAP_LCDC tileMapSource = iota
+	TILE_MAP_9800
+	TILE_MAP_9C00
+	TILE_MAP_SOURCES
+)
+
+var (
+	viewportColor = rl.Red
+	windowColor   = rl.Blue
+	gridColor     = rl.Color{R: 0x80, G: 0x80, B: 0x80, A: 0x80}
+)
+
+// tileMapView shows a whole 32x32 tile map with the screen viewport of SCX/SCY and the window origin on top
+type tileMapView struct {
+	ui     *UI
+	source tileMapSource
+	grid   bool
+}
+
+func (v *tileMapView) size() (int32, int32) {
+	return TILE_MAP_PIXEL, TILE_MAP_PIXEL
+}
+
+// tileMap returns the offset of the rendered tile map in VRAM
+func (v *tileMapView) tileMap(lcdc uint8) int {
+	switch v.source {
+	case TILE_MAP_9800:
+		return TILE_MAP_0
+	case TILE_MAP_9C00:
+		return TILE_MAP_1
+	default:
+		if lcdc&LCDC_BG_TILE_MAP != 0 {
+			return TILE_MAP_1
+		}
+
+		return TILE_MAP_0
+	}
+}
+
+// tile returns the tile number, counted from the start of VRAM, at a position of the tile map
+func (v *tileMapView) tile(vram *[VRAM_SIZE]uint8, lcdc uint8, x, y int) int {
+	idx := vram[v.tileMap(lcdc)+y*TILE_MAP_SIZE+x]
+
+	if lcdc&LCDC_TILE_DATA != 0 {
+		return int(idx)
+	}
+
+	return SIGNED_TILE_BASE + int(int8(idx))
+}
+
+func (v *tileMapView) render(pixels []rl.Color) {
+	vram := v.ui.ppu.GetVRAM()
+	palette := v.ui.ppu.Peek(BGP)
+	lcdc := v.ui.ppu.Peek(LCDC)
+
+	for y := range TILE_MAP_PIXEL {
+		for x := range TILE_MAP_PIXEL {
+			tile := v.tile(&vram, lcdc, x/TILE_SIZE, y/TILE_SIZE)
+			pixels[y*TILE_MAP_PIXEL+x] = v.ui.shade(palette, tileColor(&vram, tile, x%TILE_SIZE, y%TILE_SIZE))
+		}
+	}
+}
+
+func (v *tileMapView) overlay(dst rl.Rectangle, scale float32) {
+	rl.BeginScissorMode(int32(dst.X), int32(dst.Y), int32(dst.Width), int32(dst.Height))
+	defer rl.EndScissorMode()
+
+	thickness := max(1, scale/2)
+
+	if v.grid {
+		for i := range TILE_MAP_SIZE {
+			offset := float32(i*TILE_SIZE) * scale
+
+			rl.DrawLineV(rl.Vector2{X: dst.X + offset, Y: dst.Y}, rl.Vector2{X: dst.X + offset, Y: dst.Y + dst.Height}, gridColor)
+			rl.DrawLineV(rl.Vector2{X: dst.X, Y: dst.Y + offset}, rl.Vector2{X: dst.X + dst.Width, Y: dst.Y + offset}, gridColor)
+		}
+	}
+
+	scx := float32(v.ui.ppu.Peek(SCX))
+	scy := float32(v.ui.ppu.Peek(SCY))
+
+	// The viewport wraps around the tile map, draw it at every offset that can overlap the view
+	for _, dx := range []float32{0, -TILE_MAP_PIXEL} {
+		for _, dy := range []float32{0, -TILE_MAP_PIXEL} {
+			viewport := rl.Rectangle{
+				X:      dst.X + (scx+dx)*scale,
+				Y:      dst.Y + (scy+dy)*scale,
+				Width:  WIDTH * scale,
+				Height: HEIGHT * scale,
+			}
+
+			rl.DrawRectangleLinesEx(viewport, thickness, viewportColor)
+		}
+	}
+
+	// The window starts at WX-7, WY of the screen, which shows this point of the BG
+	if v.ui.ppu.Peek(LCDC)&LCDC_WINDOW != 0 {
+		wx := int(v.ui.ppu.Peek(WX)) - WX_OFFSET
+		wy := int(v.ui.ppu.Peek(WY))
+
+		if wx < WIDTH && wy < HEIGHT {
+			origin := rl.Vector2{
+				X: dst.X + float32((int(scx)+wx+TILE_MAP_PIXEL)%TILE_MAP_PIXEL)*scale,
+				Y: dst.Y + float32((int(scy)+wy)%TILE_MAP_PIXEL)*scale,
+			}
+
+			rl.DrawCircleV(origin, 2*thickness, windowColor)
+		}
+	}
+}
+
+func (v *tileMapView) hover(x, y int32) string {
+	vram := v.ui.ppu.GetVRAM()
+	lcdc := v.ui.ppu.Peek(LCDC)
+	tileX, tileY := int(x/TILE_SIZE), int(y/TILE_SIZE)
+	addr := VRAM_START + v.tileMap(lcdc) + tileY*TILE_MAP_SIZE + tileX
+	tile := v.tile(&vram, lcdc, tileX, tileY)
+
+	return fmt.Sprintf("$%04X (%d,%d)  index $%02X  tile $%03X", addr, tileX, tileY, vram[addr-VRAM_START], tile)
+}
@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+const (
+	VRAM_START = 0x8000
+	VRAM_SIZE  = 0x2000
+
+	BGP  = 0xFF47
+	OBP0 = 0xFF48
+	OBP1 = 0xFF49
+
+	TILE_SIZE      = 8
+	TILE_BYTE_SIZE = 16
+	// Tile data blocks 0 to 2, the DMG has no second VRAM bank
+	TILE_COUNT    = 384
+	TILES_PER_ROW = 16
+)
+
+// Palettes the tile data can be rendered with
+var tilePalettes = []struct {
+	name string
+	addr uint16
+}{
+	{"BGP", BGP},
+	{"OBP0", OBP0},
+	{"OBP1", OBP1},
+}
+
+// tileView shows every tile of VRAM, 16 per row, with a palette picked by a hotkey
+type tileView struct {
+	ui      *UI
+	palette int
+}
+
+func (v *tileView) size() (int32, int32) {
+	return TILES_PER_ROW * TILE_SIZE, TILE_COUNT / TILES_PER_ROW * TILE_SIZE
+}
+
+func (v *tileView) render(pixels []rl.Color) {
+	vram := v.ui.ppu.GetVRAM()
+	palette := v.ui.ppu.Peek(tilePalettes[v.palette].addr)
+	width, _ := v.size()
+
+	for tile := range TILE_COUNT {
+		tileX := tile % TILES_PER_ROW * TILE_SIZE
+		tileY := tile / TILES_PER_ROW * TILE_SIZE
+
+		for y := range TILE_SIZE {
+			for x := range TILE_SIZE {
+				pixels[(tileY+y)*int(width)+tileX+x] = v.ui.shade(palette, tileColor(&vram, tile, x, y))
+			}
+		}
+	}
+}
+
+func (v *tileView) overlay(dst rl.Rectangle, scale float32) {}
+
+func (v *tileView) hover(x, y int32) string {
+	tile := int(y/TILE_SIZE*TILES_PER_ROW + x/TILE_SIZE)
+
+	return fmt.Sprintf("%s  tile $%03X  $%04X", tilePalettes[v.palette].name, tile, VRAM_START+tile*TILE_BYTE_SIZE)
+}
+
+// tileColor returns the color index of a pixel of a tile, tiles are numbered from the start of VRAM
+func tileColor(vram *[VRAM_SIZE]uint8, tile, x, y int) uint8 {
+	lo := vram[tile*TILE_BYTE_SIZE+y*2]
+	hi := vram[tile*TILE_BYTE_SIZE+y*2+1]
+	bit := 7 - x
+
+	return (lo>>bit)&1 | ((hi>>bit)&1)<<1
+}
@@ -15,6 +15,8 @@ const (
 	INITIAL_SCALE = 4
 	FPS           = 60
 	AXIS_TRIGGER  = 0.5
+
+	DEFAULT_GAMEPAD = 1
 )
 
 type Console interface {
@@ -29,6 +31,8 @@ type Joypad interface {
 
 type PPU interface {
 	GetFrame() [WIDTH][HEIGHT]uint8
+	GetVRAM() [VRAM_SIZE]uint8
+	Peek(addr uint16) uint8
 }
 
 type buttonState struct {
@@ -58,6 +62,12 @@ const (
 	NOLIMIT
 	PAUSE
 	RESET
+	// Debug views
+	TILES
+	TILE_PALETTE
+	TILE_MAP
+	TILE_MAP_SOURCE
+	TILE_MAP_GRID
 )
 
 type UI struct {
@@ -74,98 +84,143 @@ type UI struct {
 	currentFPS int32
 
 	paused bool
-}
 
-var buttons = []buttonState{
-	// A
-	{
-		keyboardKeys:   []int32{rl.KeyX},
-		gamepadButtons: []int32{rl.GamepadButtonRightFaceRight, rl.GamepadButtonRightFaceUp},
-	},
-	// B
-	{
-		keyboardKeys:   []int32{rl.KeyZ},
-		gamepadButtons: []int32{rl.GamepadButtonRightFaceLeft, rl.GamepadButtonRightFaceDown},
-	},
-	// START
-	{
-		keyboardKeys:   []int32{rl.KeyEnter},
-		gamepadButtons: []int32{rl.GamepadButtonMiddleRight},
-	},
-	// SELECT
-	{
-		keyboardKeys:   []int32{rl.KeyBackspace},
-		gamepadButtons: []int32{rl.GamepadButtonMiddleLeft},
-	},
-	// UP
-	{
-		keyboardKeys:       []int32{rl.KeyUp},
-		gamepadButtons:     []int32{rl.GamepadButtonLeftFaceUp},
-		gamepadAxis:        []int32{rl.GamepadAxisLeftY},
-		gamepadAxisTrigger: -AXIS_TRIGGER,
-	},
-	// DOWN
-	{
-		keyboardKeys:       []int32{rl.KeyDown},
-		gamepadButtons:     []int32{rl.GamepadButtonLeftFaceDown},
-		gamepadAxis:        []int32{rl.GamepadAxisLeftY},
-		gamepadAxisTrigger: AXIS_TRIGGER,
-	},
-	// LEFT
-	{
-		keyboardKeys:       []int32{rl.KeyLeft},
-		gamepadButtons:     []int32{rl.GamepadButtonLeftFaceLeft},
-		gamepadAxis:        []int32{rl.GamepadAxisLeftX},
-		gamepadAxisTrigger: -AXIS_TRIGGER,
-	},
-	// RIGHT
-	{
-		keyboardKeys:       []int32{rl.KeyRight},
-		gamepadButtons:     []int32{rl.GamepadButtonLeftFaceRight},
-		gamepadAxis:        []int32{rl.GamepadAxisLeftX},
-		gamepadAxisTrigger: AXIS_TRIGGER,
-	},
-	// TURBO
-	{
-		keyboardKeys:   []int32{rl.KeySpace},
-		gamepadButtons: []int32{rl.GamepadButtonRightTrigger2},
-	},
-	// SLOWMO
-	{
-		keyboardKeys:   []int32{rl.KeyLeftShift},
-		gamepadButtons: []int32{rl.GamepadButtonLeftTrigger2},
-	},
-	// NOLIMIT
-	{
-		keyboardKeys:   []int32{rl.KeyZero},
-		gamepadButtons: []int32{},
-	},
-	// PAUSE
-	{
-		keyboardKeys:   []int32{rl.KeyRightShift},
-		gamepadButtons: []int32{rl.GamepadButtonMiddle},
-	},
-	// RESET
-	{
-		keyboardKeys:   []int32{rl.KeyTab},
-		gamepadButtons: []int32{},
-	},
-}
+	buttons []buttonState
+	palette [4]rl.Color
 
-var palette = [4]rl.Color{
-	{A: 0xFF, R: 0xFF, G: 0xFF, B: 0xFF},
-	{A: 0xFF, R: 0xAA, G: 0xAA, B: 0xAA},
-	{A: 0xFF, R: 0x55, G: 0x55, B: 0x55},
-	{A: 0xFF, R: 0x00, G: 0x00, B: 0x00},
+	panels       []*panel
+	tilePanel    *panel
+	tileView     *tileView
+	tileMapPanel *panel
+	tileMapView  *tileMapView
+
+	// TODO: better system for choosing controller
+	gamepad int32
+
+	log *log.Logger
 }
 
-// TODO: better system for choosing controller
-var gamepad = int32(1)
+func newButtons() []buttonState {
+	return []buttonState{
+		// A
+		{
+			keyboardKeys:   []int32{rl.KeyX},
+			gamepadButtons: []int32{rl.GamepadButtonRightFaceRight, rl.GamepadButtonRightFaceUp},
+		},
+		// B
+		{
+			keyboardKeys:   []int32{rl.KeyZ},
+			gamepadButtons: []int32{rl.GamepadButtonRightFaceLeft, rl.GamepadButtonRightFaceDown},
+		},
+		// START
+		{
+			keyboardKeys:   []int32{rl.KeyEnter},
+			gamepadButtons: []int32{rl.GamepadButtonMiddleRight},
+		},
+		// SELECT
+		{
+			keyboardKeys:   []int32{rl.KeyBackspace},
+			gamepadButtons: []int32{rl.GamepadButtonMiddleLeft},
+		},
+		// UP
+		{
+			keyboardKeys:       []int32{rl.KeyUp},
+			gamepadButtons:     []int32{rl.GamepadButtonLeftFaceUp},
+			gamepadAxis:        []int32{rl.GamepadAxisLeftY},
+			gamepadAxisTrigger: -AXIS_TRIGGER,
+		},
+		// DOWN
+		{
+			keyboardKeys:       []int32{rl.KeyDown},
+			gamepadButtons:     []int32{rl.GamepadButtonLeftFaceDown},
+			gamepadAxis:        []int32{rl.GamepadAxisLeftY},
+			gamepadAxisTrigger: AXIS_TRIGGER,
+		},
+		// LEFT
+		{
+			keyboardKeys:       []int32{rl.KeyLeft},
+			gamepadButtons:     []int32{rl.GamepadButtonLeftFaceLeft},
+			gamepadAxis:        []int32{rl.GamepadAxisLeftX},
+			gamepadAxisTrigger: -AXIS_TRIGGER,
+		},
+		// RIGHT
+		{
+			keyboardKeys:       []int32{rl.KeyRight},
+			gamepadButtons:     []int32{rl.GamepadButtonLeftFaceRight},
+			gamepadAxis:        []int32{rl.GamepadAxisLeftX},
+			gamepadAxisTrigger: AXIS_TRIGGER,
+		},
+		// TURBO
+		{
+			keyboardKeys:   []int32{rl.KeySpace},
+			gamepadButtons: []int32{rl.GamepadButtonRightTrigger2},
+		},
+		// SLOWMO
+		{
+			keyboardKeys:   []int32{rl.KeyLeftShift},
+			gamepadButtons: []int32{rl.GamepadButtonLeftTrigger2},
+		},
+		// NOLIMIT
+		{
+			keyboardKeys:   []int32{rl.KeyZero},
+			gamepadButtons: []int32{},
+		},
+		// PAUSE
+		{
+			keyboardKeys:   []int32{rl.KeyRightShift},
+			gamepadButtons: []int32{rl.GamepadButtonMiddle},
+		},
+		// RESET
+		{
+			keyboardKeys:   []int32{rl.KeyTab},
+			gamepadButtons: []int32{},
+		},
+		// TILES
+		{
+			keyboardKeys:   []int32{rl.KeyF1},
+			gamepadButtons: []int32{},
+		},
+		// TILE_PALETTE
+		{
+			keyboardKeys:   []int32{rl.KeyF2},
+			gamepadButtons: []int32{},
+		},
+		// TILE_MAP
+		{
+			keyboardKeys:   []int32{rl.KeyF3},
+			gamepadButtons: []int32{},
+		},
+		// TILE_MAP_SOURCE
+		{
+			keyboardKeys:   []int32{rl.KeyF4},
+			gamepadButtons: []int32{},
+		},
+		// TILE_MAP_GRID
+		{
+			keyboardKeys:   []int32{rl.KeyF5},
+			gamepadButtons: []int32{},
+		},
+	}
+}
 
-func (ui *UI) Init(console Console, joypad Joypad, ppu PPU, romPath string) {
+func (ui *UI) Init(console Console, joypad Joypad, ppu PPU, romPath string, logger *log.Logger) {
 	ui.console = console
 	ui.joypad = joypad
 	ui.ppu = ppu
+	ui.log = logger
+	ui.gamepad = DEFAULT_GAMEPAD
+
+	// Keep button states across resets so that a held reset key only resets once
+	if ui.buttons == nil {
+		ui.buttons = newButtons()
+	}
+
+	ui.palette = [4]rl.Color{
+		{A: 0xFF, R: 0xFF, G: 0xFF, B: 0xFF},
+		{A: 0xFF, R: 0xAA, G: 0xAA, B: 0xAA},
+		{A: 0xFF, R: 0x55, G: 0x55, B: 0x55},
+		{A: 0xFF, R: 0x00, G: 0x00, B: 0x00},
+	}
 
 	romFile := filepath.Base(romPath)
 	romTitle := strings.ReplaceAll(romFile, filepath.Ext(romFile), "")
@@ -180,6 +235,12 @@ func (ui *UI) Init(console Console, joypad Joypad, ppu PPU, romPath string) {
 
 		ui.texture = rl.LoadTextureFromImage(rl.GenImageColor(WIDTH, HEIGHT, rl.Black))
 		rl.SetTextureFilter(ui.texture, rl.FilterPoint)
+
+		ui.tileView = &tileView{ui: ui}
+		ui.tilePanel = newPanel(ui.tileView)
+		ui.tileMapView = &tileMapView{ui: ui}
+		ui.tileMapPanel = newPanel(ui.tileMapView)
+		ui.panels = []*panel{ui.tilePanel, ui.tileMapPanel}
 	}
 
 	// Must use make to properly initialize array for CGo calls
@@ -191,16 +252,20 @@ func (ui *UI) DrawFrame() {
 
 	for y := range HEIGHT {
 		for x := range WIDTH {
-			color := palette[frameBuffer[x][y]]
+			color := ui.palette[frameBuffer[x][y]]
 			ui.pixels[y*WIDTH+x] = color
 		}
 	}
 
 	rl.UpdateTexture(ui.texture, ui.pixels[:])
 
+	// The screen and the visible debug views are scaled together to fit the window
 	screenW := float32(rl.GetScreenWidth())
 	screenH := float32(rl.GetScreenHeight())
-	currentScale := min(screenW/WIDTH, screenH/HEIGHT)
+	layoutW, layoutH := ui.layout()
+	currentScale := min(screenW/layoutW, screenH/layoutH)
+	originX := (screenW - layoutW*currentScale) / 2
+	originY := (screenH - layoutH*currentScale) / 2
 
 	src := rl.Rectangle{
 		X:      0,
@@ -210,14 +275,16 @@ func (ui *UI) DrawFrame() {
 	}
 
 	dst := rl.Rectangle{
-		X:      (screenW - WIDTH*currentScale) / 2,
-		Y:      (screenH - HEIGHT*currentScale) / 2,
+		X:      originX,
+		Y:      originY,
 		Width:  WIDTH * currentScale,
 		Height: HEIGHT * currentScale,
 	}
 
 	rl.BeginDrawing()
+	rl.ClearBackground(rl.Black)
 	rl.DrawTexturePro(ui.texture, src, dst, rl.Vector2{}, 0, rl.White)
+	ui.drawPanels(originX+(WIDTH+VIEW_MARGIN)*currentScale, originY, currentScale)
 	rl.EndDrawing()
 
 	ui.frames++
@@ -232,21 +299,21 @@ func (ui *UI) HandleEvents() {
 
 	fpsTarget := FPS
 
-	if buttons[SLOWMO].currentlyPressed {
+	if ui.buttons[SLOWMO].currentlyPressed {
 		fpsTarget = FPS * 0.5
 	}
 
-	if buttons[TURBO].currentlyPressed {
+	if ui.buttons[TURBO].currentlyPressed {
 		fpsTarget = FPS * 4
 	}
 
-	if buttons[NOLIMIT].currentlyPressed {
+	if ui.buttons[NOLIMIT].currentlyPressed {
 		fpsTarget = 0
 	}
 
 	rl.SetTargetFPS(int32(fpsTarget))
 
-	if buttons[PAUSE].justPressed {
+	if ui.buttons[PAUSE].justPressed {
 		ui.paused = !ui.paused
 		ui.console.Pause()
 
@@ -257,11 +324,33 @@ func (ui *UI) HandleEvents() {
 		}
 	}
 
-	if buttons[RESET].justPressed {
-		log.Debug("[ui] reset")
+	if ui.buttons[RESET].justPressed {
+		ui.log.Debug("[ui] reset")
 		ui.console.Reset()
 	}
 
+	if ui.buttons[TILES].justPressed {
+		ui.tilePanel.visible = !ui.tilePanel.visible
+		ui.updateCursor()
+	}
+
+	if ui.buttons[TILE_PALETTE].justPressed && ui.tilePanel.visible {
+		ui.tileView.palette = (ui.tileView.palette + 1) % len(tilePalettes)
+	}
+
+	if ui.buttons[TILE_MAP].justPressed {
+		ui.tileMapPanel.visible = !ui.tileMapPanel.visible
+		ui.updateCursor()
+	}
+
+	if ui.buttons[TILE_MAP_SOURCE].justPressed && ui.tileMapPanel.visible {
+		ui.tileMapView.source = (ui.tileMapView.source + 1) % TILE_MAP_SOURCES
+	}
+
+	if ui.buttons[TILE_MAP_GRID].justPressed && ui.tileMapPanel.visible {
+		ui.tileMapView.grid = !ui.tileMapView.grid
+	}
+
 	ui.currentFPS = rl.GetFPS()
 
 	// Update FPS in title every second
@@ -275,7 +364,7 @@ func (ui *UI) Close() {
 }
 
 func (ui *UI) updateButtonsState() {
-	for i, b := range buttons {
+	for i, b := range ui.buttons {
 		previouslyPressed := b.currentlyPressed
 		currentlyPressed := false
 
@@ -283,34 +372,34 @@ func (ui *UI) updateButtonsState() {
 			currentlyPressed = currentlyPressed || rl.IsKeyDown(key)
 		}
 
-		if rl.IsGamepadAvailable(gamepad) {
+		if rl.IsGamepadAvailable(ui.gamepad) {
 			for _, in := range b.gamepadButtons {
-				currentlyPressed = currentlyPressed || rl.IsGamepadButtonDown(gamepad, in)
+				currentlyPressed = currentlyPressed || rl.IsGamepadButtonDown(ui.gamepad, in)
 			}
 
 			for _, axis := range b.gamepadAxis {
 				if b.gamepadAxisTrigger != 0 {
 					if b.gamepadAxisTrigger > 0 {
-						currentlyPressed = currentlyPressed || rl.GetGamepadAxisMovement(gamepad, axis) > b.gamepadAxisTrigger
+						currentlyPressed = currentlyPressed || rl.GetGamepadAxisMovement(ui.gamepad, axis) > b.gamepadAxisTrigger
 					} else {
-						currentlyPressed = currentlyPressed || rl.GetGamepadAxisMovement(gamepad, axis) < b.gamepadAxisTrigger
+						currentlyPressed = currentlyPressed || rl.GetGamepadAxisMovement(ui.gamepad, axis) < b.gamepadAxisTrigger
 					}
 				}
 			}
 		}
 
-		buttons[i].currentlyPressed = currentlyPressed
-		buttons[i].justPressed = !previouslyPressed && currentlyPressed
+		ui.buttons[i].currentlyPressed = currentlyPressed
+		ui.buttons[i].justPressed = !previouslyPressed && currentlyPressed
 	}
 
-	right := buttons[RIGHT].currentlyPressed
-	a := buttons[A].currentlyPressed
-	left := buttons[LEFT].currentlyPressed
-	b := buttons[B].currentlyPressed
-	selectB := buttons[SELECT].currentlyPressed
-	up := buttons[UP].currentlyPressed
-	start := buttons[START].currentlyPressed
-	down := buttons[DOWN].currentlyPressed
+	right := ui.buttons[RIGHT].currentlyPressed
+	a := ui.buttons[A].currentlyPressed
+	left := ui.buttons[LEFT].currentlyPressed
+	b := ui.buttons[B].currentlyPressed
+	selectB := ui.buttons[SELECT].currentlyPressed
+	up := ui.buttons[UP].currentlyPressed
+	start := ui.buttons[START].currentlyPressed
+	down := ui.buttons[DOWN].currentlyPressed
 
 	ui.joypad.UpdateButtons(a, b, right, left, up, down, selectB, start)
 }
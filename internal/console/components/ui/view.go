@@ -0,0 +1,108 @@
+package ui
+
+import (
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+const (
+	// Space between the screen and the views, in Game Boy pixels
+	VIEW_MARGIN = 8
+	// Height of the hover info line under the views, in Game Boy pixels
+	INFO_HEIGHT = 10
+)
+
+// view is a debug panel drawn to the right of the screen, its coordinates are in Game Boy pixels
+type view interface {
+	size() (width, height int32)
+	render(pixels []rl.Color)
+	// overlay draws on top of the rendered view, dst is where the view is on screen
+	overlay(dst rl.Rectangle, scale float32)
+	// hover describes what is under the given pixel of the view
+	hover(x, y int32) string
+}
+
+type panel struct {
+	view    view
+	visible bool
+	texture rl.Texture2D
+	pixels  []rl.Color
+}
+
+func newPanel(v view) *panel {
+	width, height := v.size()
+
+	texture := rl.LoadTextureFromImage(rl.GenImageColor(int(width), int(height), rl.Black))
+	rl.SetTextureFilter(texture, rl.FilterPoint)
+
+	return &panel{
+		view:    v,
+		texture: texture,
+		// Must use make to properly initialize array for CGo calls
+		pixels: make([]rl.Color, width*height),
+	}
+}
+
+// layout returns the size of the screen and the visible views side by side, in Game Boy pixels
+func (ui *UI) layout() (float32, float32) {
+	width, height := float32(WIDTH), float32(HEIGHT)
+
+	for _, p := range ui.panels {
+		if !p.visible {
+			continue
+		}
+
+		w, h := p.view.size()
+		width += VIEW_MARGIN + float32(w)
+		height = max(height, float32(h+INFO_HEIGHT))
+	}
+
+	return width, height
+}
+
+// drawPanels draws the visible views from x, the left edge of the first view on screen
+func (ui *UI) drawPanels(x, y, scale float32) {
+	mouse := rl.GetMousePosition()
+
+	for _, p := range ui.panels {
+		if !p.visible {
+			continue
+		}
+
+		w, h := p.view.size()
+
+		p.view.render(p.pixels)
+		rl.UpdateTexture(p.texture, p.pixels)
+
+		src := rl.Rectangle{Width: float32(w), Height: float32(h)}
+		dst := rl.Rectangle{X: x, Y: y, Width: float32(w) * scale, Height: float32(h) * scale}
+
+		rl.DrawTexturePro(p.texture, src, dst, rl.Vector2{}, 0, rl.White)
+
+		p.view.overlay(dst, scale)
+
+		if rl.CheckCollisionPointRec(mouse, dst) {
+			info := p.view.hover(int32((mouse.X-dst.X)/scale), int32((mouse.Y-dst.Y)/scale))
+			rl.DrawText(info, int32(dst.X), int32(dst.Y+dst.Height+scale), int32(INFO_HEIGHT*scale*0.8), rl.White)
+		}
+
+		x += (float32(w) + VIEW_MARGIN) * scale
+	}
+}
+
+// updateCursor shows the cursor while a view is visible so that it can be hovered
+func (ui *UI) updateCursor() {
+	for _, p := range ui.panels {
+		if p.visible {
+			rl.ShowCursor()
+
+			return
+		}
+	}
+
+	rl.HideCursor()
+}
+
+// shade returns the color of a color index through a palette register
+func (ui *UI) shade(palette uint8, color uint8) rl.Color {
+	return ui.palette[(palette>>(color*2))&0x3]
+}
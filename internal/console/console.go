@@ -2,64 +2,60 @@ package console
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 
-	"github.com/cterence/gbgo/internal/console/components/apu"
 	"github.com/cterence/gbgo/internal/console/components/bus"
 	"github.com/cterence/gbgo/internal/console/components/cartridge"
+	"github.com/cterence/gbgo/internal/console/components/cdl"
 	"github.com/cterence/gbgo/internal/console/components/cpu"
 	"github.com/cterence/gbgo/internal/console/components/debugger"
-	"github.com/cterence/gbgo/internal/console/components/dma"
-	"github.com/cterence/gbgo/internal/console/components/joypad"
-	"github.com/cterence/gbgo/internal/console/components/memory"
 	"github.com/cterence/gbgo/internal/console/components/ppu"
 	"github.com/cterence/gbgo/internal/console/components/serial"
-	"github.com/cterence/gbgo/internal/console/components/timer"
 	"github.com/cterence/gbgo/internal/console/components/ui"
+	"github.com/cterence/gbgo/internal/console/core"
 	"github.com/cterence/gbgo/internal/log"
 )
 
-type serializable interface {
-	Load(*bytes.Reader)
-	Save(*bytes.Buffer)
-}
-
-type state struct {
-	Bytes [][]uint8
-}
+const (
+	// Value gameboy-doctor expects LY reads to return
+	DOCTOR_LY = 0x90
+	// 154 lines of 456 dots
+	CYCLES_PER_FRAME = core.CYCLES_PER_FRAME
+)
 
 type console struct {
-	cpu       *cpu.CPU
-	memory    *memory.Memory
-	cartridge *cartridge.Cartridge
-	bus       *bus.Bus
-	timer     *timer.Timer
-	joypad    *joypad.Joypad
-	ui        *ui.UI
-	ppu       *ppu.PPU
-	serial    *serial.Serial
-	dma       *dma.DMA
-	debugger  *debugger.Debugger
-	apu       *apu.APU
-
-	romPath  string
-	stateDir string
-
-	cpuOptions    []cpu.Option
-	busOptions    []bus.Option
-	serialOptions []serial.Option
-
-	headless    bool
-	stopped     bool
-	paused      bool
-	noState     bool
-	shouldClose bool
-	debug       bool
+	core *core.Core
+
+	ui       *ui.UI
+	debugger *debugger.Debugger
+	cdl      *cdl.CDL
+
+	romPath     string
+	stateDir    string
+	traceFile   string
+	traceWriter io.Writer
+	traceCloser io.Closer
+	cdlPath     string
+
+	coreOptions     []core.Option
+	debuggerOptions []debugger.Option
+
+	shouldClose atomic.Bool
+
+	log *log.Logger
+
+	headless bool
+	paused   bool
+	noState  bool
+	debug    bool
 }
 
 type Option func(*console)
@@ -70,9 +66,24 @@ func WithHeadless() Option {
 	}
 }
 
+// WithFastPath ticks the other components once per instruction instead of at every CPU memory access.
+// It is faster, but memory accesses see the state of the machine up to an instruction late.
+func WithFastPath() Option {
+	return func(c *console) {
+		c.coreOptions = append(c.coreOptions, core.WithFastPath())
+	}
+}
+
 func WithPrintSerial() Option {
 	return func(c *console) {
-		c.serialOptions = append(c.serialOptions, serial.WithPrintSerial())
+		c.coreOptions = append(c.coreOptions, core.WithSerialOptions(serial.WithPrintSerial()))
+	}
+}
+
+// WithSerialWriter writes the bytes sent over the serial port to w.
+func WithSerialWriter(w io.Writer) Option {
+	return func(c *console) {
+		c.coreOptions = append(c.coreOptions, core.WithSerialOptions(serial.WithWriter(w)))
 	}
 }
 
@@ -82,211 +93,326 @@ func WithNoState() Option {
 	}
 }
 
+// WithNoSave never reads nor writes the external RAM save file.
+func WithNoSave() Option {
+	return func(c *console) {
+		c.coreOptions = append(c.coreOptions, core.WithCartridgeOptions(cartridge.WithNoSave()))
+	}
+}
+
 func WithDebug() Option {
 	return func(c *console) {
-		c.debug = true
-		c.cpuOptions = append(c.cpuOptions, cpu.WithDebug())
+		c.enableTrace()
+		c.log = log.New(true)
+		c.coreOptions = append(c.coreOptions, core.WithLogger(c.log), core.WithCartridgeOptions(cartridge.WithLogger(c.log)))
 	}
 }
 
-func WithBootROM(bootRom []uint8) Option {
+// WithTrace enables CPU tracing without debug logs so that traces can be compared with other emulators.
+func WithTrace(format debugger.Format) Option {
 	return func(c *console) {
-		c.busOptions = append(c.busOptions, bus.WithBootROM(bootRom))
-		c.cpuOptions = append(c.cpuOptions, cpu.WithBootROM())
+		c.enableTrace()
+		c.debuggerOptions = append(c.debuggerOptions, debugger.WithFormat(format))
+
+		if format == debugger.FORMAT_DOCTOR {
+			c.coreOptions = append(c.coreOptions, core.WithPPUOptions(ppu.WithStubbedLY(DOCTOR_LY)))
+		}
 	}
 }
 
-func Run(romBytes []uint8, romPath, stateDir string, options ...Option) error {
-	gb := console{
-		romPath:   romPath,
-		stateDir:  stateDir,
-		cpu:       &cpu.CPU{},
-		memory:    &memory.Memory{},
-		cartridge: &cartridge.Cartridge{},
-		bus:       &bus.Bus{},
-		timer:     &timer.Timer{},
-		joypad:    &joypad.Joypad{},
-		ui:        &ui.UI{},
-		ppu:       &ppu.PPU{},
-		serial:    &serial.Serial{},
-		dma:       &dma.DMA{},
-		debugger:  &debugger.Debugger{},
-		apu:       &apu.APU{},
+// WithTraceFile enables CPU tracing to the given file instead of stdout.
+func WithTraceFile(path string) Option {
+	return func(c *console) {
+		c.enableTrace()
+		c.traceFile = path
 	}
+}
 
-	for _, o := range options {
-		o(&gb)
+// WithTraceWriter enables CPU tracing to w instead of stdout.
+func WithTraceWriter(w io.Writer) Option {
+	return func(c *console) {
+		c.enableTrace()
+		c.traceWriter = w
 	}
+}
 
-	err := gb.cartridge.Init(romPath, stateDir, romBytes[0x147], romBytes[0x148], romBytes[0x149])
-	if err != nil {
-		return fmt.Errorf("failed to init cartridge: %w", err)
+// WithTraceFilter only traces the events matching filter.
+func WithTraceFilter(filter debugger.Filter) Option {
+	return func(c *console) {
+		c.enableTrace()
+		c.debuggerOptions = append(c.debuggerOptions, debugger.WithFilter(filter))
 	}
-	defer gb.cartridge.Close()
+}
 
-	gb.Reset()
+// WithTraceDrop drops traces when the writer can't keep up instead of slowing down emulation.
+func WithTraceDrop() Option {
+	return func(c *console) {
+		c.debuggerOptions = append(c.debuggerOptions, debugger.WithPolicy(debugger.DROP))
+	}
+}
 
-	if !gb.headless {
-		defer gb.ui.Close()
+// WithCDL records ROM and RAM accesses to a CDL file, along with JSON and HTML coverage reports.
+// An existing CDL file is merged so that coverage accumulates across runs.
+func WithCDL(path string) Option {
+	return func(c *console) {
+		c.cdlPath = path
 	}
+}
 
-	for i, b := range romBytes {
-		gb.cartridge.Load(uint32(i), b)
+func WithBootROM(bootRom []uint8) Option {
+	return func(c *console) {
+		c.coreOptions = append(c.coreOptions, core.WithBootROM(bootRom))
 	}
+}
 
-	if !gb.noState {
-		gb.loadState()
-		defer gb.saveState()
+func newConsole(romBytes []uint8, romPath, stateDir string, options ...Option) (*console, error) {
+	gb := &console{
+		romPath:  romPath,
+		stateDir: stateDir,
+		ui:       &ui.UI{},
+		debugger: &debugger.Debugger{},
+		cdl:      &cdl.CDL{},
 	}
 
-	totalCycles := uint64(0)
+	for _, o := range options {
+		o(gb)
+	}
 
-	for !gb.shouldClose {
-		cycles := 4
+	gb.coreOptions = append(gb.coreOptions, core.WithDebugger(gb.debugger))
 
-		if !gb.paused {
-			if !gb.stopped {
-				cycles = gb.cpu.Step()
-				gb.timer.Step(cycles)
-			}
+	if gb.cdlPath != "" {
+		gb.coreOptions = append(gb.coreOptions, core.WithBusOptions(bus.WithCDL(gb.cdl)))
+	}
 
-			gb.serial.Step(cycles)
-			gb.dma.Step(cycles)
-			gb.apu.Step(cycles)
+	c, err := core.New(romBytes, romPath, stateDir, gb.coreOptions...)
+	if err != nil {
+		return nil, err
+	}
 
-			for range cycles / 2 {
-				gb.ppu.Step(2)
-			}
-		}
+	gb.core = c
 
-		if !gb.headless && (gb.ppu.IsFrameReady() || gb.paused) {
-			gb.ui.HandleEvents()
-			gb.ui.DrawFrame()
-		}
+	if gb.cdlPath != "" {
+		gb.cdl.Init(c.Cartridge, len(romBytes), c.Cartridge.GetRAMSize())
 
-		totalCycles += uint64(cycles)
+		if err := gb.loadCDL(); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
+	return gb, nil
 }
 
-func (gb *console) Reset() {
-	gb.cpu.Init(gb.bus, gb, gb.debugger, gb.cpuOptions...)
-	gb.memory.Init()
-	gb.timer.Init(gb.cpu)
-	gb.ppu.Init(gb.bus, gb.cpu)
-	gb.serial.Init(gb.cpu, gb.serialOptions...)
-	gb.dma.Init(gb.bus, gb.ppu)
-	gb.joypad.Init(gb.cpu)
-	gb.bus.Init(gb.memory, gb.cartridge, gb.cpu, gb.timer, gb.ppu, gb.serial, gb.dma, gb.joypad, gb.apu, gb.busOptions...)
-	gb.apu.Init()
+func Run(ctx context.Context, romBytes []uint8, romPath, stateDir string, options ...Option) error {
+	gb, err := newConsole(romBytes, romPath, stateDir, options...)
+	if err != nil {
+		return err
+	}
+	defer gb.core.Cartridge.Close()
 
-	if gb.debug {
-		gb.debugger.Init(os.Stdout)
+	if err := gb.openDebugger(); err != nil {
+		return err
 	}
+	defer gb.closeDebugger()
 
-	if !gb.headless {
-		gb.ui.Init(gb, gb.joypad, gb.ppu, gb.romPath)
+	if gb.cdlPath != "" {
+		defer gb.saveCDL()
 	}
-}
 
-func Disassemble(romBytes []uint8) error {
-	pc := 0
+	// Stop the main loop on interrupt so that deferred flushes still run
+	stopShutdown := context.AfterFunc(ctx, gb.Shutdown)
+	defer stopShutdown()
+
+	gb.Reset()
 
-	if err := cpu.ParseOpcodes(); err != nil {
-		return fmt.Errorf("failed to parse CPU opcodes: %w", err)
+	if !gb.headless {
+		defer gb.ui.Close()
 	}
 
-	var sb strings.Builder
+	if !gb.noState {
+		gb.loadState()
+		defer gb.saveState()
+	}
 
-	for pc < len(romBytes) {
-		opcode := cpu.UnprefixedOpcodes[romBytes[pc]]
+	for !gb.shouldClose.Load() {
+		gb.runFrame()
 
-		if opcode.Mnemonic == "PREFIX" {
-			pc++
-			opcode = cpu.CBPrefixedOpcodes[romBytes[pc]]
+		if !gb.headless {
+			gb.ui.HandleEvents()
+			gb.ui.DrawFrame()
 		}
+	}
+
+	return nil
+}
+
+// runFrame emulates until the PPU completes a frame, or for a frame worth of cycles while the LCD is off
+func (gb *console) runFrame() {
+	if gb.paused {
+		return
+	}
 
-		fmt.Printf("%04X - %s\n", pc, opcode)
+	for cycles := 0; cycles < CYCLES_PER_FRAME; {
+		cycles += gb.core.Step()
 
-		pc += int(opcode.Bytes)
+		if gb.core.PPU.IsFrameReady() || gb.paused || gb.shouldClose.Load() {
+			return
+		}
 	}
+}
 
-	fmt.Print(sb.String())
+func (gb *console) Reset() {
+	gb.core.Reset()
 
-	return nil
+	if !gb.headless {
+		gb.ui.Init(gb, gb.core.Joypad, gb.core.PPU, gb.romPath, gb.log)
+	}
 }
 
 func (gb *console) Shutdown() {
-	gb.shouldClose = true
+	gb.shouldClose.Store(true)
 }
 
 func (gb *console) Pause() {
 	gb.paused = !gb.paused
 	if gb.paused {
-		log.Debug("[console] paused")
+		gb.log.Debug("[console] paused")
 	} else {
-		log.Debug("[console] unpaused")
+		gb.log.Debug("[console] unpaused")
 	}
 }
 
-func (gb *console) Stop() {
-	log.Debug("[console] stop")
+// openDebugger starts the trace writer when tracing is enabled
+func (gb *console) openDebugger() error {
+	if !gb.debug {
+		return nil
+	}
+
+	traceWriter := gb.traceWriter
+	if traceWriter == nil {
+		traceWriter = os.Stdout
+	}
+
+	if gb.traceFile != "" {
+		f, err := os.Create(gb.traceFile)
+		if err != nil {
+			return fmt.Errorf("failed to create trace file: %w", err)
+		}
+
+		gb.traceCloser = f
+		traceWriter = f
+	}
+
+	gb.debugger.Init(traceWriter, gb.core.Cartridge, gb.core.PPU, gb.debuggerOptions...)
 
-	gb.stopped = true
+	return nil
 }
 
-func (gb *console) getSerializables() []serializable {
-	return []serializable{gb.cpu, gb.memory, gb.ppu, gb.timer}
+func (gb *console) closeDebugger() {
+	if !gb.debug {
+		return
+	}
+
+	if err := gb.debugger.Close(); err != nil {
+		fmt.Printf("failed to close debugger: %v\n", err)
+	}
+
+	if gb.traceCloser != nil {
+		if err := gb.traceCloser.Close(); err != nil {
+			fmt.Printf("failed to close trace file: %v\n", err)
+		}
+
+		gb.traceCloser = nil
+	}
 }
 
-func (gb *console) loadState() {
-	saveStatePath := strings.ReplaceAll(filepath.Base(gb.romPath), filepath.Ext(gb.romPath), ".state")
+func (gb *console) enableTrace() {
+	gb.debug = true
+	gb.coreOptions = append(gb.coreOptions, core.WithCPUOptions(cpu.WithDebug()))
+}
 
-	stateBytes, err := os.ReadFile(filepath.Join(gb.stateDir, saveStatePath))
+func (gb *console) loadCDL() error {
+	f, err := os.Open(gb.cdlPath)
 	if err != nil {
-		if !strings.Contains(err.Error(), "no such file or directory") {
-			fmt.Printf("failed to open save state file: %v\n", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
 		}
 
-		return
+		return fmt.Errorf("failed to open CDL file: %w", err)
 	}
 
-	var st state
-
-	r := bytes.NewReader(stateBytes)
-	dec := gob.NewDecoder(r)
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("failed to close CDL file: %v\n", err)
+		}
+	}()
 
-	err = dec.Decode(&st)
+	previous, err := cdl.Read(f)
 	if err != nil {
-		fmt.Printf("failed to decode save state file: %v\n", err)
-		return
+		return err
 	}
 
-	ser := gb.getSerializables()
-
-	for i, s := range st.Bytes {
-		ser[i].Load(bytes.NewReader(s))
+	if err := gb.cdl.Merge(previous); err != nil {
+		return fmt.Errorf("failed to merge CDL file: %w", err)
 	}
 
-	log.Debug("[console] loaded state from %s", saveStatePath)
+	gb.log.Debug("[console] loaded CDL from %s", gb.cdlPath)
+
+	return nil
 }
 
-func (gb *console) saveState() {
-	var st state
+func (gb *console) saveCDL() {
+	reportPath := strings.TrimSuffix(gb.cdlPath, filepath.Ext(gb.cdlPath))
 
-	for _, s := range gb.getSerializables() {
-		buf := bytes.NewBuffer(nil)
-		s.Save(buf)
+	outputs := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{gb.cdlPath, gb.cdl.Write},
+		{reportPath + ".json", gb.cdl.WriteJSON},
+		{reportPath + ".html", gb.cdl.WriteHTML},
+	}
 
-		state, err := io.ReadAll(buf)
+	for _, o := range outputs {
+		f, err := os.Create(o.path)
 		if err != nil {
-			fmt.Printf("failed to read save state buffer: %v\n", err)
+			fmt.Printf("failed to create CDL file: %v\n", err)
+
+			continue
+		}
+
+		if err := o.write(f); err != nil {
+			fmt.Printf("failed to write CDL file: %v\n", err)
 		}
 
-		st.Bytes = append(st.Bytes, state)
+		if err := f.Close(); err != nil {
+			fmt.Printf("failed to close CDL file: %v\n", err)
+		}
 	}
 
+	gb.log.Debug("[console] saved CDL to %s", gb.cdlPath)
+}
+
+func (gb *console) loadState() {
+	saveStatePath := strings.ReplaceAll(filepath.Base(gb.romPath), filepath.Ext(gb.romPath), ".state")
+
+	stateBytes, err := os.ReadFile(filepath.Join(gb.stateDir, saveStatePath))
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such file or directory") {
+			fmt.Printf("failed to open save state file: %v\n", err)
+		}
+
+		return
+	}
+
+	if err := gb.core.LoadState(bytes.NewReader(stateBytes)); err != nil {
+		fmt.Printf("failed to load save state file: %v\n", err)
+		return
+	}
+
+	gb.log.Debug("[console] loaded state from %s", saveStatePath)
+}
+
+func (gb *console) saveState() {
 	saveStatePath := strings.ReplaceAll(filepath.Base(gb.romPath), filepath.Ext(gb.romPath), ".state")
 
 	f, err := os.Create(filepath.Join(gb.stateDir, saveStatePath))
@@ -302,12 +428,9 @@ func (gb *console) saveState() {
 		}
 	}()
 
-	enc := gob.NewEncoder(f)
-
-	err = enc.Encode(st)
-	if err != nil {
+	if err := gb.core.SaveState(f); err != nil {
 		fmt.Printf("failed to encode save state: %v\n", err)
 	}
 
-	log.Debug("[console] saved state to %s", saveStatePath)
+	gb.log.Debug("[console] saved state to %s", saveStatePath)
 }
@@ -0,0 +1,324 @@
+// Package core wires the components of a Game Boy together, the console and the gameboy package drive it
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cterence/gbgo/internal/console/components/apu"
+	"github.com/cterence/gbgo/internal/console/components/bus"
+	"github.com/cterence/gbgo/internal/console/components/cartridge"
+	"github.com/cterence/gbgo/internal/console/components/cpu"
+	"github.com/cterence/gbgo/internal/console/components/dma"
+	"github.com/cterence/gbgo/internal/console/components/joypad"
+	"github.com/cterence/gbgo/internal/console/components/memory"
+	"github.com/cterence/gbgo/internal/console/components/ppu"
+	"github.com/cterence/gbgo/internal/console/components/scheduler"
+	"github.com/cterence/gbgo/internal/console/components/serial"
+	"github.com/cterence/gbgo/internal/console/components/timer"
+	"github.com/cterence/gbgo/internal/log"
+)
+
+const (
+	// 154 lines of 456 dots
+	CYCLES_PER_FRAME = 70224
+
+	HEADER_END     = 0x014F
+	CARTRIDGE_TYPE = 0x0147
+	ROM_SIZE       = 0x0148
+	RAM_SIZE       = 0x0149
+)
+
+type serializable interface {
+	Load(*bytes.Reader) error
+	Save(*bytes.Buffer)
+}
+
+type state struct {
+	Bytes [][]uint8
+}
+
+type Core struct {
+	CPU       *cpu.CPU
+	Memory    *memory.Memory
+	Cartridge *cartridge.Cartridge
+	Bus       *bus.Bus
+	Timer     *timer.Timer
+	Joypad    *joypad.Joypad
+	PPU       *ppu.PPU
+	Serial    *serial.Serial
+	DMA       *dma.DMA
+	APU       *apu.APU
+	Scheduler *scheduler.Scheduler
+
+	debugger cpu.Debugger
+	log      *log.Logger
+
+	cpuOptions       []cpu.Option
+	cartridgeOptions []cartridge.Option
+	busOptions       []bus.Option
+	ppuOptions       []ppu.Option
+	serialOptions    []serial.Option
+
+	fastPath bool
+	stopped  bool
+	cycles   uint64
+}
+
+type Option func(*Core)
+
+// WithFastPath ticks the other components once per instruction instead of at every CPU memory access.
+// It is faster, but memory accesses see the state of the machine up to an instruction late.
+func WithFastPath() Option {
+	return func(c *Core) {
+		c.fastPath = true
+	}
+}
+
+func WithBootROM(bootRom []uint8) Option {
+	return func(c *Core) {
+		c.busOptions = append(c.busOptions, bus.WithBootROM(bootRom))
+		c.cpuOptions = append(c.cpuOptions, cpu.WithBootROM())
+	}
+}
+
+// WithDebugger receives the CPU traces, the CPU must also be given cpu.WithDebug
+func WithDebugger(d cpu.Debugger) Option {
+	return func(c *Core) {
+		c.debugger = d
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Core) {
+		c.log = logger
+	}
+}
+
+func WithCPUOptions(options ...cpu.Option) Option {
+	return func(c *Core) {
+		c.cpuOptions = append(c.cpuOptions, options...)
+	}
+}
+
+func WithCartridgeOptions(options ...cartridge.Option) Option {
+	return func(c *Core) {
+		c.cartridgeOptions = append(c.cartridgeOptions, options...)
+	}
+}
+
+func WithBusOptions(options ...bus.Option) Option {
+	return func(c *Core) {
+		c.busOptions = append(c.busOptions, options...)
+	}
+}
+
+func WithPPUOptions(options ...ppu.Option) Option {
+	return func(c *Core) {
+		c.ppuOptions = append(c.ppuOptions, options...)
+	}
+}
+
+func WithSerialOptions(options ...serial.Option) Option {
+	return func(c *Core) {
+		c.serialOptions = append(c.serialOptions, options...)
+	}
+}
+
+// New inserts a ROM in a Game Boy, the external RAM save file is in stateDir unless cartridge.WithNoSave is given.
+// Reset powers it on.
+func New(rom []uint8, romPath, stateDir string, options ...Option) (*Core, error) {
+	if len(rom) <= HEADER_END {
+		return nil, fmt.Errorf("rom is too small: %d bytes", len(rom))
+	}
+
+	c := &Core{
+		CPU:       &cpu.CPU{},
+		Memory:    &memory.Memory{},
+		Cartridge: &cartridge.Cartridge{},
+		Bus:       &bus.Bus{},
+		Timer:     &timer.Timer{},
+		Joypad:    &joypad.Joypad{},
+		PPU:       &ppu.PPU{},
+		Serial:    &serial.Serial{},
+		DMA:       &dma.DMA{},
+		APU:       &apu.APU{},
+		Scheduler: &scheduler.Scheduler{},
+	}
+
+	for _, o := range options {
+		o(c)
+	}
+
+	c.cpuOptions = append(c.cpuOptions, cpu.WithOAMBug(c.PPU))
+
+	if !c.fastPath {
+		c.cpuOptions = append(c.cpuOptions, cpu.WithClock(clock{c}))
+	}
+
+	err := c.Cartridge.Init(romPath, stateDir, rom[CARTRIDGE_TYPE], rom[ROM_SIZE], rom[RAM_SIZE], c.cartridgeOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cartridge: %w", err)
+	}
+
+	if err := c.Cartridge.LoadROM(rom); err != nil {
+		return nil, fmt.Errorf("failed to load rom: %w", err)
+	}
+
+	return c, nil
+}
+
+// Reset powers the Game Boy on again, the cartridge keeps its ROM and external RAM
+func (c *Core) Reset() {
+	c.CPU.Init(c.Bus, cpuConsole{c}, c.debugger, c.cpuOptions...)
+	c.Memory.Init()
+	c.Timer.Init(c.CPU)
+	c.PPU.Init(c.Bus, c.CPU, c.ppuOptions...)
+	c.Scheduler.Init()
+	c.Serial.Init(c.CPU, c.Scheduler, c.serialOptions...)
+	c.DMA.Init(c.Bus, c.PPU)
+	c.Joypad.Init(c.CPU)
+	c.Bus.Init(c.Memory, c.Cartridge, c.CPU, c.Timer, c.PPU, c.Serial, c.DMA, c.Joypad, c.APU, c.busOptions...)
+	c.APU.Init()
+
+	c.stopped = false
+	c.cycles = 0
+}
+
+// Step emulates a single CPU instruction, or an M-cycle in STOP mode, and returns its duration in T-cycles
+func (c *Core) Step() int {
+	cycles := 4
+
+	if c.stopped && c.Joypad.Pressed() {
+		c.log.Debug("[core] woken up")
+
+		c.stopped = false
+	}
+
+	switch {
+	case c.stopped:
+		c.tick(cycles)
+	case c.fastPath:
+		cycles = c.CPU.Step()
+		c.tick(cycles)
+	default:
+		// The CPU has already ticked the clock
+		cycles = c.CPU.Step()
+	}
+
+	c.cycles += uint64(cycles)
+
+	return cycles
+}
+
+// tick advances every component but the CPU, the timer and the PPU don't run in STOP mode
+func (c *Core) tick(cycles int) {
+	if !c.stopped {
+		c.Timer.Step(cycles)
+
+		for range cycles / 2 {
+			c.PPU.Step(2)
+		}
+	}
+
+	c.DMA.Step(cycles)
+	c.APU.Step(cycles)
+
+	c.Scheduler.Advance(cycles)
+}
+
+// Cycles returns the number of T-cycles emulated since power on
+func (c *Core) Cycles() uint64 {
+	return c.cycles
+}
+
+func (c *Core) SaveState(w io.Writer) error {
+	var st state
+
+	for _, s := range c.getSerializables() {
+		buf := bytes.NewBuffer(nil)
+		s.Save(buf)
+
+		st.Bytes = append(st.Bytes, buf.Bytes())
+	}
+
+	if err := gob.NewEncoder(w).Encode(st); err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	return nil
+}
+
+// LoadState restores a state written by SaveState, the Game Boy is left untouched when it fails
+func (c *Core) LoadState(r io.Reader) error {
+	var previous bytes.Buffer
+
+	if err := c.SaveState(&previous); err != nil {
+		return err
+	}
+
+	if err := c.loadState(r); err != nil {
+		if restoreErr := c.loadState(&previous); restoreErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to restore previous state: %w", restoreErr))
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (c *Core) loadState(r io.Reader) error {
+	var st state
+
+	if err := gob.NewDecoder(r).Decode(&st); err != nil {
+		return fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	ser := c.getSerializables()
+
+	if len(st.Bytes) != len(ser) {
+		return errors.New("state doesn't match this emulator version")
+	}
+
+	for i, s := range st.Bytes {
+		if err := ser[i].Load(bytes.NewReader(s)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Core) getSerializables() []serializable {
+	return []serializable{c.CPU, c.Memory, c.PPU, c.Timer, c.Scheduler, c.Cartridge, c.DMA, c.Serial, c.Joypad, c.Bus, c.APU}
+}
+
+// cpuConsole receives STOP from the CPU without exposing it to the users of the core
+type cpuConsole struct {
+	c *Core
+}
+
+func (con cpuConsole) Stop() {
+	con.c.log.Debug("[core] stop")
+
+	con.c.stopped = true
+	con.c.Timer.Write(timer.DIV, 0)
+	con.c.PPU.Blank()
+}
+
+func (con cpuConsole) ButtonHeld() bool {
+	return con.c.Joypad.Pressed()
+}
+
+// clock advances the other components while the CPU accesses memory
+type clock struct {
+	c *Core
+}
+
+func (cl clock) Tick(cycles int) {
+	cl.c.tick(cycles)
+}
@@ -0,0 +1,116 @@
+package console
+
+import (
+	"github.com/cterence/gbgo/internal/console/components/ppu"
+)
+
+const (
+	// Opcode of LD B,B, used as a breakpoint by test ROMs
+	LD_B_B = 0x40
+)
+
+// Registers is a snapshot of the CPU registers
+type Registers struct {
+	A, F, B, C, D, E, H, L uint8
+	SP, PC                 uint16
+}
+
+// Headless is a console without UI, state nor saves that is driven by its caller, e.g. a test
+type Headless struct {
+	gb *console
+}
+
+func NewHeadless(romBytes []uint8, options ...Option) (*Headless, error) {
+	gb, err := newConsole(romBytes, "", "", append(options, WithHeadless(), WithNoState(), WithNoSave())...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gb.openDebugger(); err != nil {
+		return nil, err
+	}
+
+	gb.Reset()
+
+	return &Headless{gb: gb}, nil
+}
+
+// Close flushes the traces and saves the CDL, the console must not be used afterwards
+func (h *Headless) Close() {
+	h.gb.closeDebugger()
+
+	if h.gb.cdlPath != "" {
+		h.gb.saveCDL()
+	}
+
+	h.gb.core.Cartridge.Close()
+}
+
+// Step emulates a single CPU instruction and returns its duration in T-cycles
+func (h *Headless) Step() int {
+	return h.gb.core.Step()
+}
+
+// RunUntil steps the console until done returns true or maxCycles have elapsed, and reports whether done returned true
+func (h *Headless) RunUntil(maxCycles uint64, done func() bool) bool {
+	for start := h.Cycles(); h.Cycles()-start < maxCycles; {
+		h.Step()
+
+		if done() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AtBreakpoint reports whether the last instruction was LD B,B
+func (h *Headless) AtBreakpoint() bool {
+	return h.gb.core.CPU.Executed(LD_B_B)
+}
+
+// Cycles returns the number of T-cycles emulated since power on
+func (h *Headless) Cycles() uint64 {
+	return h.gb.core.Cycles()
+}
+
+func (h *Headless) Registers() Registers {
+	c := h.gb.core.CPU
+
+	return Registers{
+		A:  c.A,
+		F:  c.F,
+		B:  c.B,
+		C:  c.C,
+		D:  c.D,
+		E:  c.E,
+		H:  c.H,
+		L:  c.L,
+		SP: c.SP,
+		PC: c.PC,
+	}
+}
+
+// Peek reads memory without side effects
+func (h *Headless) Peek(addr uint16) uint8 {
+	return h.gb.core.Bus.Peek(addr)
+}
+
+// Poke writes memory without side effects, writes to ROM patch it
+func (h *Headless) Poke(addr uint16, value uint8) {
+	h.gb.core.Bus.Poke(addr, value)
+}
+
+// PeekBank reads an address of ROM or external RAM in the given bank, mapped or not
+func (h *Headless) PeekBank(bank int, addr uint16) uint8 {
+	return h.gb.core.Bus.PeekBank(bank, addr)
+}
+
+// Frame returns the last completed frame
+func (h *Headless) Frame() [ppu.WIDTH][ppu.HEIGHT]uint8 {
+	return h.gb.core.PPU.GetFrame()
+}
+
+func (h *Headless) FrameCount() uint64 {
+	return h.gb.core.PPU.GetFrameCount()
+}
@@ -0,0 +1,561 @@
+package disassembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cterence/gbgo/internal/console/components/cdl"
+	"github.com/cterence/gbgo/internal/console/components/cpu"
+)
+
+const (
+	ROM_BANK_SIZE    = 0x4000
+	ROM_BANK_0_END   = 0x3FFF
+	ROM_BANK_1_START = 0x4000
+	ROM_BANK_1_END   = 0x7FFF
+
+	MBC_BANK_SELECT_START = 0x2000
+	MBC_BANK_SELECT_END   = 0x3FFF
+
+	ENTRYPOINT = 0x0100
+
+	DATA_BYTES_PER_LINE = 8
+)
+
+// RST and interrupt vectors
+var vectors = []uint16{0x00, 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38, 0x40, 0x48, 0x50, 0x58, 0x60}
+
+type byteKind uint8
+
+const (
+	DATA byteKind = iota
+	OPCODE
+	OPERAND
+)
+
+// Location is a CPU address in a given ROM bank
+type Location struct {
+	Bank uint16
+	Addr uint16
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%02X:%04X", l.Bank, l.Addr)
+}
+
+type Instruction struct {
+	Location
+	Opcode *cpu.Opcode
+	Bytes  []uint8
+
+	// Branch target, only valid if HasTarget
+	Target    Location
+	HasTarget bool
+}
+
+type Disassembly struct {
+	rom          []uint8
+	bankCount    int
+	kinds        []byteKind
+	instructions map[int]*Instruction
+	labels       map[int]string
+	cdl          []cdl.Flag
+
+	unprefixed cpu.OpcodeTable
+	cbPrefixed cpu.OpcodeTable
+}
+
+// cursor follows a single code path
+type cursor struct {
+	Location
+
+	// Bank mapped at 0x4000-0x7FFF and value of A, -1 when unknown
+	mappedBank int
+	a          int
+}
+
+type Option func(*Disassembly)
+
+// WithSymbols seeds the disassembly with the given symbols and uses them as labels
+func WithSymbols(symbols map[Location]string) Option {
+	return func(d *Disassembly) {
+		for loc, name := range symbols {
+			if offset, ok := d.offset(loc); ok {
+				d.labels[offset] = name
+			}
+		}
+	}
+}
+
+// WithCDL uses the ROM flags recorded while running the game: executed opcodes become entrypoints
+// and bytes only read as data are never decoded as code
+func WithCDL(c *cdl.CDL) Option {
+	return func(d *Disassembly) {
+		d.cdl = c.ROM.Flags
+	}
+}
+
+// Disassemble follows the code paths of a ROM starting from the vectors, the entrypoint and any supplied symbol.
+// Bytes never reached are considered data.
+func Disassemble(rom []uint8, options ...Option) (*Disassembly, error) {
+	unprefixed, cbPrefixed, err := cpu.ParseOpcodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CPU opcodes: %w", err)
+	}
+
+	d := &Disassembly{
+		rom:          rom,
+		bankCount:    (len(rom) + ROM_BANK_SIZE - 1) / ROM_BANK_SIZE,
+		kinds:        make([]byteKind, len(rom)),
+		instructions: map[int]*Instruction{},
+		labels:       map[int]string{},
+		unprefixed:   unprefixed,
+		cbPrefixed:   cbPrefixed,
+	}
+
+	for _, o := range options {
+		o(d)
+	}
+
+	if d.cdl != nil && len(d.cdl) != len(rom) {
+		return nil, fmt.Errorf("CDL size %d doesn't match ROM size %d", len(d.cdl), len(rom))
+	}
+
+	var seeds []cursor
+
+	for _, v := range append(slices.Clone(vectors), ENTRYPOINT) {
+		seeds = append(seeds, d.newCursor(Location{Bank: 0, Addr: v}))
+	}
+
+	for _, offset := range slices.Sorted(maps.Keys(d.labels)) {
+		seeds = append(seeds, d.newCursor(d.location(offset)))
+	}
+
+	for offset, f := range d.cdl {
+		if f&cdl.EXECUTED != 0 {
+			seeds = append(seeds, d.newCursor(d.location(offset)))
+		}
+	}
+
+	d.trace(seeds)
+
+	return d, nil
+}
+
+// ParseSymbols reads an RGBDS symbol file, made of "bank:addr name" lines
+func ParseSymbols(r io.Reader) (map[Location]string, error) {
+	symbols := map[Location]string{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), ";")
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		bankStr, addrStr, found := strings.Cut(fields[0], ":")
+		if len(fields) != 2 || !found {
+			return nil, fmt.Errorf("invalid symbol on line %d: %s", line, scanner.Text())
+		}
+
+		bank, err := strconv.ParseUint(bankStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid symbol bank on line %d: %w", line, err)
+		}
+
+		addr, err := strconv.ParseUint(addrStr, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid symbol address on line %d: %w", line, err)
+		}
+
+		symbols[Location{Bank: uint16(bank), Addr: uint16(addr)}] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read symbols: %w", err)
+	}
+
+	return symbols, nil
+}
+
+func (d *Disassembly) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for offset := 0; offset < len(d.rom); {
+		loc := d.location(offset)
+
+		if label, ok := d.labels[offset]; ok {
+			fmt.Fprintf(bw, "%s:\n", label)
+		}
+
+		if inst, ok := d.instructions[offset]; ok {
+			raw := make([]string, len(inst.Bytes))
+			for i, b := range inst.Bytes {
+				raw[i] = fmt.Sprintf("%02X", b)
+			}
+
+			fmt.Fprintf(bw, "%s  %-8s  %s\n", loc, strings.Join(raw, " "), d.format(inst, style{labels: d.labels}))
+
+			offset += len(inst.Bytes)
+
+			continue
+		}
+
+		end := d.dataEnd(offset, DATA_BYTES_PER_LINE, d.labels)
+
+		fmt.Fprintf(bw, "%s  %-8s  db %s\n", loc, "", formatBytes(d.rom[offset:end]))
+
+		offset = end
+	}
+
+	return bw.Flush()
+}
+
+func (d *Disassembly) newCursor(loc Location) cursor {
+	c := cursor{Location: loc, mappedBank: -1, a: -1}
+
+	if loc.Bank != 0 {
+		c.mappedBank = int(loc.Bank)
+	}
+
+	return c
+}
+
+func (d *Disassembly) trace(queue []cursor) {
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		for {
+			inst, ok := d.decode(c.Location)
+			if !ok {
+				break
+			}
+
+			d.track(&c, inst)
+
+			if inst.HasTarget {
+				queue = append(queue, cursor{Location: inst.Target, mappedBank: c.mappedBank, a: c.a})
+			}
+
+			// The callee may clobber A
+			if inst.Opcode.Mnemonic == "CALL" || inst.Opcode.Mnemonic == "RST" {
+				c.a = -1
+			}
+
+			if endsFlow(inst.Opcode) {
+				break
+			}
+
+			c.Addr += uint16(len(inst.Bytes))
+		}
+	}
+}
+
+// decode reads and marks the instruction at loc, it fails on data, illegal opcodes, overlaps and bank boundaries
+func (d *Disassembly) decode(loc Location) (*Instruction, bool) {
+	offset, ok := d.offset(loc)
+	if !ok || d.kinds[offset] != DATA {
+		return nil, false
+	}
+
+	opcode := &d.unprefixed[d.rom[offset]]
+
+	if opcode.Mnemonic == "PREFIX" {
+		if offset+1 >= d.bankEnd(offset) {
+			return nil, false
+		}
+
+		opcode = &d.cbPrefixed[d.rom[offset+1]]
+	}
+
+	size := int(opcode.Bytes)
+	if strings.HasPrefix(opcode.Mnemonic, "ILLEGAL") || offset+size > d.bankEnd(offset) {
+		return nil, false
+	}
+
+	for i := range size {
+		if d.kinds[offset+i] != DATA || !d.loggedAs(offset+i, i == 0) {
+			return nil, false
+		}
+	}
+
+	inst := &Instruction{
+		Location: loc,
+		Opcode:   opcode,
+		Bytes:    d.rom[offset : offset+size],
+	}
+
+	d.kinds[offset] = OPCODE
+	for i := 1; i < size; i++ {
+		d.kinds[offset+i] = OPERAND
+	}
+
+	d.instructions[offset] = inst
+
+	return inst, true
+}
+
+// loggedAs reports whether the CDL allows a byte to be an opcode or an operand, unlogged bytes are allowed
+func (d *Disassembly) loggedAs(offset int, opcode bool) bool {
+	if d.cdl == nil || d.cdl[offset] == 0 {
+		return true
+	}
+
+	if opcode {
+		return d.cdl[offset]&cdl.EXECUTED != 0
+	}
+
+	return d.cdl[offset]&cdl.EXECUTED == 0 && d.cdl[offset]&cdl.OPERAND != 0
+}
+
+// track resolves the branch target of inst and keeps track of MBC bank switches
+func (d *Disassembly) track(c *cursor, inst *Instruction) {
+	opc := inst.Opcode
+	next := inst.Addr + uint16(len(inst.Bytes))
+
+	var (
+		target    uint16
+		hasTarget bool
+	)
+
+	switch opc.Mnemonic {
+	case "JP", "CALL":
+		if op := opc.Operands[len(opc.Operands)-1]; op.Name == "a16" {
+			target = inst.word()
+			hasTarget = true
+		}
+	case "JR":
+		target = next + uint16(int8(inst.Bytes[1]))
+		hasTarget = true
+	case "RST":
+		v, _ := strconv.ParseUint(opc.Operands[0].Name[1:], 16, 16)
+		target = uint16(v)
+		hasTarget = true
+	case "LD":
+		op0 := opc.Operands[0]
+		op1 := opc.Operands[1]
+
+		switch {
+		case op0.Name == "A" && op1.Name == "n8":
+			c.a = int(inst.Bytes[1])
+
+			return
+		case op0.Name == "a16" && op1.Name == "A":
+			// Writing to the MBC register maps another bank at 0x4000
+			if addr := inst.word(); addr >= MBC_BANK_SELECT_START && addr <= MBC_BANK_SELECT_END && c.a >= 0 && c.Bank == 0 {
+				c.mappedBank = max(c.a%d.bankCount, 1)
+			}
+
+			return
+		}
+	}
+
+	if writesA(opc) {
+		c.a = -1
+	}
+
+	if hasTarget {
+		inst.Target, inst.HasTarget = d.resolve(*c, target)
+	}
+}
+
+// writesA reports whether an instruction may change A, A is then no longer known
+func writesA(opc *cpu.Opcode) bool {
+	switch opc.Mnemonic {
+	case "RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL":
+		return true
+	case "CP", "BIT":
+		return false
+	case "POP":
+		return opc.Operands[0].Name == "AF"
+	case "RES", "SET":
+		return opc.Operands[1].Name == "A"
+	}
+
+	return len(opc.Operands) > 0 && opc.Operands[0].Name == "A"
+}
+
+// resolve finds the bank a CPU address points to from the code path
+func (d *Disassembly) resolve(c cursor, addr uint16) (Location, bool) {
+	switch {
+	case addr <= ROM_BANK_0_END:
+		return Location{Bank: 0, Addr: addr}, true
+	case addr <= ROM_BANK_1_END:
+		if c.Bank != 0 {
+			return Location{Bank: c.Bank, Addr: addr}, true
+		}
+
+		if c.mappedBank >= 0 {
+			return Location{Bank: uint16(c.mappedBank), Addr: addr}, true
+		}
+
+		// ROMs without MBC always have bank 1 mapped
+		if d.bankCount == 2 {
+			return Location{Bank: 1, Addr: addr}, true
+		}
+
+		return Location{}, false
+	default:
+		return Location{}, false
+	}
+}
+
+// style changes how instructions are formatted
+type style struct {
+	labels map[int]string
+	lower  bool
+}
+
+func (d *Disassembly) format(inst *Instruction, st style) string {
+	opc := inst.Opcode
+	operands := make([]string, 0, len(opc.Operands))
+
+	for i, op := range opc.Operands {
+		var s string
+
+		switch op.Name {
+		case "n8":
+			s = fmt.Sprintf("$%02X", inst.Bytes[1])
+		case "n16", "a16":
+			s = d.addr(inst, inst.word(), st.labels)
+		case "a8":
+			s = fmt.Sprintf("$FF%02X", inst.Bytes[1])
+		case "e8":
+			switch {
+			case opc.Mnemonic == "JR":
+				s = d.addr(inst, inst.Addr+uint16(len(inst.Bytes))+uint16(int8(inst.Bytes[1])), st.labels)
+			case opc.Mnemonic == "LD":
+				// LD HL, SP+e8
+				operands[i-1] += fmt.Sprintf("%+d", int8(inst.Bytes[1]))
+
+				continue
+			default:
+				s = strconv.Itoa(int(int8(inst.Bytes[1])))
+			}
+		default:
+			s = op.Name
+
+			if st.lower {
+				s = strings.ToLower(s)
+			}
+		}
+
+		if !op.Immediate {
+			if op.Increment {
+				s += "+"
+			}
+
+			if op.Decrement {
+				s += "-"
+			}
+
+			s = "[" + s + "]"
+		}
+
+		operands = append(operands, s)
+	}
+
+	mnemonic := opc.Mnemonic
+	if st.lower {
+		mnemonic = strings.ToLower(mnemonic)
+	}
+
+	if len(operands) == 0 {
+		return mnemonic
+	}
+
+	return fmt.Sprintf("%-4s %s", mnemonic, strings.Join(operands, ", "))
+}
+
+// addr formats an address operand, using the target label when there is one
+func (d *Disassembly) addr(inst *Instruction, addr uint16, labels map[int]string) string {
+	if inst.HasTarget && inst.Target.Addr == addr {
+		if offset, ok := d.offset(inst.Target); ok {
+			if label, ok := labels[offset]; ok {
+				return label
+			}
+		}
+	}
+
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// dataEnd returns the end of the data run starting at offset, stopping at code, labels and bank boundaries
+func (d *Disassembly) dataEnd(offset, maxLen int, labels map[int]string) int {
+	end := offset + 1
+	bankEnd := d.bankEnd(offset)
+
+	for end < bankEnd && end-offset < maxLen && d.kinds[end] == DATA {
+		if _, ok := labels[end]; ok {
+			break
+		}
+
+		end++
+	}
+
+	return end
+}
+
+func (d *Disassembly) offset(loc Location) (int, bool) {
+	var offset int
+
+	switch {
+	case loc.Addr <= ROM_BANK_0_END && loc.Bank == 0:
+		offset = int(loc.Addr)
+	case loc.Addr >= ROM_BANK_1_START && loc.Addr <= ROM_BANK_1_END && loc.Bank != 0:
+		offset = int(loc.Bank)*ROM_BANK_SIZE + int(loc.Addr-ROM_BANK_1_START)
+	default:
+		return 0, false
+	}
+
+	return offset, offset < len(d.rom)
+}
+
+func (d *Disassembly) location(offset int) Location {
+	bank := offset / ROM_BANK_SIZE
+	if bank == 0 {
+		return Location{Bank: 0, Addr: uint16(offset)}
+	}
+
+	return Location{Bank: uint16(bank), Addr: ROM_BANK_1_START + uint16(offset%ROM_BANK_SIZE)}
+}
+
+func (d *Disassembly) bankEnd(offset int) int {
+	return min((offset/ROM_BANK_SIZE+1)*ROM_BANK_SIZE, len(d.rom))
+}
+
+func formatBytes(b []uint8) string {
+	values := make([]string, len(b))
+	for i, v := range b {
+		values[i] = fmt.Sprintf("$%02X", v)
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (inst *Instruction) word() uint16 {
+	return uint16(inst.Bytes[2])<<8 | uint16(inst.Bytes[1])
+}
+
+// endsFlow reports whether execution never continues to the next instruction
+func endsFlow(opc *cpu.Opcode) bool {
+	// Conditional variants have a condition as first operand
+	switch opc.Mnemonic {
+	case "JP", "JR":
+		return len(opc.Operands) == 1
+	case "RET":
+		return len(opc.Operands) == 0
+	case "RETI":
+		return true
+	default:
+		return false
+	}
+}
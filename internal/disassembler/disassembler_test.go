@@ -0,0 +1,389 @@
+package disassembler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cterence/gbgo/internal/console/components/cdl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newROM returns a ROM of the given number of banks filled with illegal opcodes, so that only the given code is decoded
+func newROM(banks int, code map[int][]uint8) []uint8 {
+	rom := make([]uint8, banks*ROM_BANK_SIZE)
+
+	for i := range rom {
+		rom[i] = 0xD3
+	}
+
+	for offset, b := range code {
+		copy(rom[offset:], b)
+	}
+
+	return rom
+}
+
+func Test_Disassemble(t *testing.T) {
+	tests := []struct {
+		name    string
+		banks   int
+		code    map[int][]uint8
+		symbols map[Location]string
+		// Mnemonics expected at each instruction and the branch targets that must be resolved
+		instructions map[Location]string
+		targets      map[Location]Location
+		data         []Location
+	}{
+		{
+			name:  "vectors",
+			banks: 2,
+			code: map[int][]uint8{
+				0x0000: {0xC9},             // ret
+				0x0040: {0x00, 0xD9},       // nop, reti
+				0x0060: {0xC3, 0x00, 0x00}, // jp $0000
+			},
+			instructions: map[Location]string{
+				{Addr: 0x0000}: "RET",
+				{Addr: 0x0040}: "NOP",
+				{Addr: 0x0041}: "RETI",
+				{Addr: 0x0060}: "JP",
+			},
+			data: []Location{{Addr: 0x0001}, {Addr: 0x0042}},
+		},
+		{
+			name:  "entrypoint",
+			banks: 2,
+			code: map[int][]uint8{
+				0x0100: {0x00, 0xC3, 0x50, 0x01}, // nop, jp $0150
+				0x0150: {0xCD, 0x00, 0x20},       // call $2000
+				0x0153: {0x18, 0xFE},             // jr $0153
+				0x2000: {0x3C, 0xC9},             // inc a, ret
+			},
+			instructions: map[Location]string{
+				{Addr: 0x0100}: "NOP",
+				{Addr: 0x0101}: "JP",
+				{Addr: 0x0150}: "CALL",
+				{Addr: 0x0153}: "JR",
+				{Addr: 0x2000}: "INC",
+				{Addr: 0x2001}: "RET",
+			},
+			targets: map[Location]Location{
+				{Addr: 0x0101}: {Addr: 0x0150},
+				{Addr: 0x0150}: {Addr: 0x2000},
+				{Addr: 0x0153}: {Addr: 0x0153},
+			},
+			data: []Location{{Addr: 0x0104}, {Addr: 0x0155}, {Addr: 0x2002}},
+		},
+		{
+			name:  "ROM without MBC maps bank 1",
+			banks: 2,
+			code: map[int][]uint8{
+				0x0100: {0xC3, 0x00, 0x40}, // jp $4000
+				0x4000: {0xC9},             // ret
+			},
+			instructions: map[Location]string{
+				{Bank: 1, Addr: 0x4000}: "RET",
+			},
+			targets: map[Location]Location{
+				{Addr: 0x0100}: {Bank: 1, Addr: 0x4000},
+			},
+		},
+		{
+			name:  "MBC bank switch",
+			banks: 4,
+			code: map[int][]uint8{
+				0x0100: {
+					0x3E, 0x03, // ld a, $03
+					0xEA, 0x00, 0x20, // ld [$2000], a
+					0xCD, 0x00, 0x40, // call $4000
+					0x18, 0xFE, // jr $0108
+				},
+				3 * ROM_BANK_SIZE:      {0xC3, 0x10, 0x40}, // jp $4010
+				3*ROM_BANK_SIZE + 0x10: {0xC9},             // ret
+			},
+			instructions: map[Location]string{
+				{Bank: 3, Addr: 0x4000}: "JP",
+				{Bank: 3, Addr: 0x4010}: "RET",
+			},
+			targets: map[Location]Location{
+				{Addr: 0x0105}:          {Bank: 3, Addr: 0x4000},
+				{Bank: 3, Addr: 0x4000}: {Bank: 3, Addr: 0x4010},
+			},
+			data: []Location{{Bank: 1, Addr: 0x4000}, {Bank: 2, Addr: 0x4000}},
+		},
+		{
+			name:  "MBC bank 0 maps bank 1",
+			banks: 4,
+			code: map[int][]uint8{
+				0x0100: {
+					0xAF,       // xor a
+					0x3E, 0x00, // ld a, $00
+					0xEA, 0x00, 0x30, // ld [$3000], a
+					0xC3, 0x00, 0x40, // jp $4000
+				},
+				ROM_BANK_SIZE: {0xC9}, // ret
+			},
+			targets: map[Location]Location{
+				{Addr: 0x0106}: {Bank: 1, Addr: 0x4000},
+			},
+			instructions: map[Location]string{
+				{Bank: 1, Addr: 0x4000}: "RET",
+			},
+		},
+		{
+			name:  "unknown bank",
+			banks: 4,
+			code: map[int][]uint8{
+				0x0100: {
+					0x3C,             // inc a
+					0xEA, 0x00, 0x20, // ld [$2000], a
+					0xC3, 0x00, 0x40, // jp $4000
+				},
+				ROM_BANK_SIZE: {0xC9}, // ret
+			},
+			instructions: map[Location]string{
+				{Addr: 0x0104}: "JP",
+			},
+			data: []Location{{Bank: 1, Addr: 0x4000}},
+		},
+		{
+			name:  "symbols are entrypoints",
+			banks: 4,
+			code: map[int][]uint8{
+				0x0100:                   {0x18, 0xFE}, // jr $0100
+				2*ROM_BANK_SIZE + 0x0200: {0xC9},       // ret
+			},
+			symbols: map[Location]string{
+				{Bank: 2, Addr: 0x4200}: "Routine",
+			},
+			instructions: map[Location]string{
+				{Bank: 2, Addr: 0x4200}: "RET",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rom := newROM(tt.banks, tt.code)
+
+			d, err := Disassemble(rom, WithSymbols(tt.symbols))
+			require.NoError(t, err)
+
+			for loc, mnemonic := range tt.instructions {
+				inst := instructionAt(t, d, loc)
+				assert.Equal(t, mnemonic, inst.Opcode.Mnemonic, "instruction at %s", loc)
+			}
+
+			for loc, target := range tt.targets {
+				inst := instructionAt(t, d, loc)
+				assert.True(t, inst.HasTarget, "target of %s", loc)
+				assert.Equal(t, target, inst.Target, "target of %s", loc)
+			}
+
+			for _, loc := range tt.data {
+				offset, ok := d.offset(loc)
+				require.True(t, ok, "offset of %s", loc)
+				assert.Equal(t, DATA, d.kinds[offset], "kind at %s", loc)
+			}
+		})
+	}
+}
+
+func instructionAt(t *testing.T, d *Disassembly, loc Location) *Instruction {
+	t.Helper()
+
+	offset, ok := d.offset(loc)
+	require.True(t, ok, "offset of %s", loc)
+
+	inst, ok := d.instructions[offset]
+	require.True(t, ok, "no instruction at %s", loc)
+
+	return inst
+}
+
+// A tracked bank number must be forgotten by every instruction that writes A, and kept by the others
+func Test_Disassemble_Writes_A(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []uint8
+		writes bool
+	}{
+		{name: "inc a", code: []uint8{0x3C}, writes: true},
+		{name: "add a, b", code: []uint8{0x80}, writes: true},
+		{name: "xor n8", code: []uint8{0xEE, 0x01}, writes: true},
+		{name: "ld a, b", code: []uint8{0x78}, writes: true},
+		{name: "ld a, [hl]", code: []uint8{0x7E}, writes: true},
+		{name: "ld a, [hl+]", code: []uint8{0x2A}, writes: true},
+		{name: "ld a, [a16]", code: []uint8{0xFA, 0x00, 0xC0}, writes: true},
+		{name: "ldh a, [a8]", code: []uint8{0xF0, 0x80}, writes: true},
+		{name: "pop af", code: []uint8{0xF1}, writes: true},
+		{name: "cpl", code: []uint8{0x2F}, writes: true},
+		{name: "daa", code: []uint8{0x27}, writes: true},
+		{name: "rlca", code: []uint8{0x07}, writes: true},
+		{name: "rra", code: []uint8{0x1F}, writes: true},
+		{name: "swap a", code: []uint8{0xCB, 0x37}, writes: true},
+		{name: "set 0, a", code: []uint8{0xCB, 0xC7}, writes: true},
+		{name: "res 7, a", code: []uint8{0xCB, 0xBF}, writes: true},
+		{name: "cp n8", code: []uint8{0xFE, 0x01}},
+		{name: "bit 0, a", code: []uint8{0xCB, 0x47}},
+		{name: "ld b, a", code: []uint8{0x47}},
+		{name: "ld [hl], a", code: []uint8{0x77}},
+		{name: "pop bc", code: []uint8{0xC1}},
+		{name: "set 0, b", code: []uint8{0xCB, 0xC0}},
+		{name: "inc b", code: []uint8{0x04}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := []uint8{0x3E, 0x03} // ld a, $03
+			code = append(code, tt.code...)
+			code = append(code,
+				0xEA, 0x00, 0x20, // ld [$2000], a
+				0xC3, 0x00, 0x40, // jp $4000
+			)
+
+			rom := newROM(4, map[int][]uint8{
+				0x0100:            code,
+				3 * ROM_BANK_SIZE: {0xC9}, // ret
+			})
+
+			d, err := Disassemble(rom)
+			require.NoError(t, err)
+
+			jp := instructionAt(t, d, Location{Addr: uint16(0x0100 + len(code) - 3)})
+
+			if tt.writes {
+				assert.False(t, jp.HasTarget, "the bank of the target is unknown")
+			} else {
+				assert.True(t, jp.HasTarget)
+				assert.Equal(t, Location{Bank: 3, Addr: 0x4000}, jp.Target)
+			}
+		})
+	}
+}
+
+func Test_ParseSymbols(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[Location]string
+		wantErr string
+	}{
+		{
+			name:  "symbols",
+			input: "00:0150 Main\n01:4000 Bank1.start\n1F:7FFF End\n",
+			want: map[Location]string{
+				{Bank: 0x00, Addr: 0x0150}: "Main",
+				{Bank: 0x01, Addr: 0x4000}: "Bank1.start",
+				{Bank: 0x1F, Addr: 0x7FFF}: "End",
+			},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "; File generated by rgblink\n\n00:0000 Reset ; vector\n   \n",
+			want: map[Location]string{
+				{Bank: 0, Addr: 0x0000}: "Reset",
+			},
+		},
+		{
+			name:    "missing bank",
+			input:   "0150 Main\n",
+			wantErr: "invalid symbol on line 1",
+		},
+		{
+			name:    "missing name",
+			input:   "00:0150 Main\n00:0200\n",
+			wantErr: "invalid symbol on line 2",
+		},
+		{
+			name:    "invalid bank",
+			input:   "ZZ:0150 Main\n",
+			wantErr: "invalid symbol bank on line 1",
+		},
+		{
+			name:    "invalid address",
+			input:   "00:10000 Main\n",
+			wantErr: "invalid symbol address on line 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols, err := ParseSymbols(strings.NewReader(tt.input))
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, symbols)
+		})
+	}
+}
+
+func Test_Disassemble_With_CDL(t *testing.T) {
+	rom := newROM(2, map[int][]uint8{
+		0x0100: {0xC3, 0x50, 0x01}, // jp $0150
+		0x0150: {0x21, 0x00, 0x02}, // ld hl, $0200
+		0x0153: {0x18, 0xFE},       // jr $0153
+		// Only reached through jp hl
+		0x0180: {0x3C, 0xC9}, // inc a, ret
+		// Table read by the code, it would decode as nop, ld bc, $0201
+		0x0200: {0x00, 0x01, 0x01, 0x02},
+	})
+
+	c := &cdl.CDL{}
+	c.Init(nil, len(rom), 0)
+
+	c.ROM.Flags[0x0180] = cdl.EXECUTED
+	c.ROM.Flags[0x0181] = cdl.EXECUTED
+
+	for offset := 0x0200; offset < 0x0204; offset++ {
+		c.ROM.Flags[offset] = cdl.READ
+	}
+
+	// Seed the table as code, the CDL must still keep it as data
+	d, err := Disassemble(rom, WithCDL(c), WithSymbols(map[Location]string{{Addr: 0x0200}: "Table"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "INC", instructionAt(t, d, Location{Addr: 0x0180}).Opcode.Mnemonic)
+	assert.Equal(t, "RET", instructionAt(t, d, Location{Addr: 0x0181}).Opcode.Mnemonic)
+
+	for offset := 0x0200; offset < 0x0204; offset++ {
+		assert.Equal(t, DATA, d.kinds[offset], "kind at %04X", offset)
+	}
+
+	// Unlogged bytes are still traced
+	assert.Equal(t, "LD", instructionAt(t, d, Location{Addr: 0x0150}).Opcode.Mnemonic)
+}
+
+func Test_Disassemble_With_CDL_Operand(t *testing.T) {
+	rom := newROM(2, map[int][]uint8{
+		0x0100: {0xC3, 0x50, 0x01}, // jp $0150
+		0x0150: {0x3E, 0x3C},       // ld a, $3C
+		0x0152: {0xC9},             // ret
+	})
+
+	c := &cdl.CDL{}
+	c.Init(nil, len(rom), 0)
+
+	c.ROM.Flags[0x0150] = cdl.EXECUTED
+	c.ROM.Flags[0x0151] = cdl.OPERAND
+
+	// A symbol on the operand doesn't turn it into an inc a
+	d, err := Disassemble(rom, WithCDL(c), WithSymbols(map[Location]string{{Addr: 0x0151}: "Value"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "LD", instructionAt(t, d, Location{Addr: 0x0150}).Opcode.Mnemonic)
+	assert.Equal(t, OPERAND, d.kinds[0x0151])
+}
+
+func Test_Disassemble_With_CDL_Size_Mismatch(t *testing.T) {
+	c := &cdl.CDL{}
+	c.Init(nil, ROM_BANK_SIZE, 0)
+
+	_, err := Disassemble(newROM(2, nil), WithCDL(c))
+	assert.ErrorContains(t, err, "doesn't match ROM size")
+}
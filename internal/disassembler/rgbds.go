@@ -0,0 +1,242 @@
+package disassembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+const (
+	HEADER_LOGO_START  = 0x0104
+	HEADER_TITLE_START = 0x0134
+	HEADER_CGB_FLAG    = 0x0143
+	HEADER_END         = 0x014F
+
+	HIGH_RAM_PAGE = 0xFF00
+
+	// Runs of identical data bytes at least this long are written as a ds fill
+	FILL_MIN_BYTES = 16
+)
+
+type headerField struct {
+	name string
+	addr uint16
+	size int
+}
+
+// Fields after the title, in header order
+var headerFields = []headerField{
+	{name: "HEADER_CGB_FLAG", addr: 0x0143, size: 1},
+	{name: "HEADER_NEW_LICENSEE_CODE", addr: 0x0144, size: 2},
+	{name: "HEADER_SGB_FLAG", addr: 0x0146, size: 1},
+	{name: "HEADER_CARTRIDGE_TYPE", addr: 0x0147, size: 1},
+	{name: "HEADER_ROM_SIZE", addr: 0x0148, size: 1},
+	{name: "HEADER_RAM_SIZE", addr: 0x0149, size: 1},
+	{name: "HEADER_DESTINATION_CODE", addr: 0x014A, size: 1},
+	{name: "HEADER_OLD_LICENSEE_CODE", addr: 0x014B, size: 1},
+	{name: "HEADER_ROM_VERSION", addr: 0x014C, size: 1},
+	{name: "HEADER_CHECKSUM", addr: 0x014D, size: 1},
+	{name: "HEADER_GLOBAL_CHECKSUM", addr: 0x014E, size: 2},
+}
+
+// WriteRGBDS writes the disassembly as RGBDS assembly that rgbasm and rgblink rebuild into the exact same ROM.
+// rgblink writes whole banks, so the ROM size must be a multiple of the bank size.
+func (d *Disassembly) WriteRGBDS(w io.Writer) error {
+	if len(d.rom)%ROM_BANK_SIZE != 0 {
+		return fmt.Errorf("rom size %d isn't a multiple of the bank size %d, rgblink would pad its last bank", len(d.rom), ROM_BANK_SIZE)
+	}
+
+	bw := bufio.NewWriter(w)
+	labels, operandSymbols := d.rgbdsLabels()
+	header := d.hasDataHeader(labels)
+
+	fmt.Fprintf(bw, "; Disassembled by gbgo\n\n")
+
+	if header {
+		for _, f := range headerFields {
+			fmt.Fprintf(bw, "DEF %s EQU $%0*X\n", f.name, f.size*2, d.headerValue(f))
+		}
+
+		fmt.Fprintln(bw)
+	}
+
+	for offset := 0; offset < len(d.rom); {
+		if offset%ROM_BANK_SIZE == 0 {
+			bank := offset / ROM_BANK_SIZE
+
+			if bank == 0 {
+				fmt.Fprintf(bw, "SECTION \"ROM Bank $000\", ROM0[$0000]\n\n")
+			} else {
+				fmt.Fprintf(bw, "\nSECTION \"ROM Bank $%03X\", ROMX[$4000], BANK[$%03X]\n\n", bank, bank)
+			}
+		}
+
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(bw, "%s:\n", label)
+		}
+
+		if header && offset == HEADER_LOGO_START {
+			d.writeRGBDSHeader(bw)
+
+			offset = HEADER_END + 1
+
+			continue
+		}
+
+		if inst, ok := d.instructions[offset]; ok {
+			fmt.Fprintf(bw, "\t%s\n", d.formatRGBDS(inst, labels))
+
+			for _, symbol := range operandSymbols[offset] {
+				fmt.Fprintf(bw, "DEF %s EQU %s+%d\n", labels[symbol], labels[offset], symbol-offset)
+			}
+
+			offset += len(inst.Bytes)
+
+			continue
+		}
+
+		if end := d.fillEnd(offset, labels); end-offset >= FILL_MIN_BYTES {
+			fmt.Fprintf(bw, "\tds %d, $%02X\n", end-offset, d.rom[offset])
+
+			offset = end
+
+			continue
+		}
+
+		end := d.dataEnd(offset, DATA_BYTES_PER_LINE, labels)
+
+		fmt.Fprintf(bw, "\tdb %s\n", formatBytes(d.rom[offset:end]))
+
+		offset = end
+	}
+
+	return bw.Flush()
+}
+
+// rgbdsLabels adds generated labels to every branch target that doesn't have a symbol.
+// Labels can't be placed in the middle of an instruction, symbols pointing into operands are returned by the offset
+// of their instruction so that they can be defined as an offset from its label.
+func (d *Disassembly) rgbdsLabels() (map[int]string, map[int][]int) {
+	labels := maps.Clone(d.labels)
+	operandSymbols := map[int][]int{}
+
+	for _, inst := range d.instructions {
+		if !inst.HasTarget {
+			continue
+		}
+
+		offset, ok := d.offset(inst.Target)
+		if !ok || d.kinds[offset] == OPERAND {
+			continue
+		}
+
+		if _, ok := labels[offset]; !ok {
+			labels[offset] = generatedLabel(inst.Target)
+		}
+	}
+
+	for _, offset := range slices.Sorted(maps.Keys(d.labels)) {
+		if d.kinds[offset] != OPERAND {
+			continue
+		}
+
+		start := d.instructionStart(offset)
+
+		if _, ok := labels[start]; !ok {
+			labels[start] = generatedLabel(d.location(start))
+		}
+
+		operandSymbols[start] = append(operandSymbols[start], offset)
+	}
+
+	return labels, operandSymbols
+}
+
+func (d *Disassembly) instructionStart(offset int) int {
+	for d.kinds[offset] == OPERAND {
+		offset--
+	}
+
+	return offset
+}
+
+// fillEnd returns the end of the run of identical data bytes at offset, it stops at labels and bank boundaries
+func (d *Disassembly) fillEnd(offset int, labels map[int]string) int {
+	dataEnd := d.dataEnd(offset, ROM_BANK_SIZE, labels)
+
+	end := offset + 1
+	for end < dataEnd && d.rom[end] == d.rom[offset] {
+		end++
+	}
+
+	return end
+}
+
+func generatedLabel(loc Location) string {
+	return fmt.Sprintf("L%03X_%04X", loc.Bank, loc.Addr)
+}
+
+// formatRGBDS formats an instruction, falling back to raw bytes when rgbasm could pick another encoding
+func (d *Disassembly) formatRGBDS(inst *Instruction, labels map[int]string) string {
+	opc := inst.Opcode
+	asm := d.format(inst, style{labels: labels, lower: true})
+
+	switch {
+	// rgbasm always emits a zero operand for stop
+	case opc.Mnemonic == "STOP" && inst.Bytes[1] != 0,
+		// Some rgbasm versions optimize these into ldh
+		opc.Mnemonic == "LD" && len(inst.Bytes) == 3 && inst.word() >= HIGH_RAM_PAGE && (opc.Operands[0].Name == "a16" || opc.Operands[1].Name == "a16"):
+		return fmt.Sprintf("db %s ; %s", formatBytes(inst.Bytes), asm)
+	default:
+		return asm
+	}
+}
+
+// hasDataHeader reports whether the cartridge header was left untouched by code and labels
+func (d *Disassembly) hasDataHeader(labels map[int]string) bool {
+	if len(d.rom) <= HEADER_END {
+		return false
+	}
+
+	for offset := HEADER_LOGO_START; offset <= HEADER_END; offset++ {
+		if _, ok := labels[offset]; d.kinds[offset] != DATA || ok && offset != HEADER_LOGO_START {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *Disassembly) headerValue(f headerField) int {
+	// Multi-byte header fields are big endian
+	value := 0
+	for i := range f.size {
+		value = value<<8 | int(d.rom[int(f.addr)+i])
+	}
+
+	return value
+}
+
+func (d *Disassembly) writeRGBDSHeader(w io.Writer) {
+	fmt.Fprintf(w, "HeaderLogo:\n")
+
+	for offset := HEADER_LOGO_START; offset < HEADER_TITLE_START; offset += DATA_BYTES_PER_LINE {
+		fmt.Fprintf(w, "\tdb %s\n", formatBytes(d.rom[offset:min(offset+DATA_BYTES_PER_LINE, HEADER_TITLE_START)]))
+	}
+
+	fmt.Fprintf(w, "HeaderTitle:\n")
+
+	for offset := HEADER_TITLE_START; offset < HEADER_CGB_FLAG; offset += DATA_BYTES_PER_LINE {
+		fmt.Fprintf(w, "\tdb %s\n", formatBytes(d.rom[offset:min(offset+DATA_BYTES_PER_LINE, HEADER_CGB_FLAG)]))
+	}
+
+	for _, f := range headerFields {
+		if f.size == 2 {
+			fmt.Fprintf(w, "\tdb HIGH(%s), LOW(%s)\n", f.name, f.name)
+		} else {
+			fmt.Fprintf(w, "\tdb %s\n", f.name)
+		}
+	}
+}
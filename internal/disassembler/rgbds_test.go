@@ -0,0 +1,172 @@
+package disassembler
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const RGBDS_GOLDEN = "testdata/symbols.asm"
+
+var update = flag.Bool("update", false, "write the golden files from the current output")
+
+// symbolsROM loads immediate values at the first vector and the entrypoint, then branches into their operands
+func symbolsROM() []uint8 {
+	rom := make([]uint8, 2*ROM_BANK_SIZE)
+
+	for i := range ENTRYPOINT {
+		rom[i] = 0xC9 // ret
+	}
+
+	copy(rom, []uint8{
+		0x21, 0x34, 0x12, // ld hl, $1234
+		0xC9, // ret
+	})
+
+	copy(rom[0x100:], []uint8{
+		0x3E, 0x05, // ld a, $05
+		0x18, 0x4C, // jr $0150
+	})
+
+	copy(rom[0x150:], []uint8{
+		0xCD, 0x01, 0x00, // call $0001
+		0xC3, 0x01, 0x01, // jp $0101
+	})
+
+	return rom
+}
+
+func Test_WriteRGBDS_Golden(t *testing.T) {
+	d, err := Disassemble(symbolsROM(), WithSymbols(map[Location]string{
+		{Bank: 0, Addr: 0x0100}: "Start",
+		// Operands of ld hl, $1234 and ld a, $05
+		{Bank: 0, Addr: 0x0001}: "Pointer",
+		{Bank: 0, Addr: 0x0101}: "Value",
+	}))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, d.WriteRGBDS(&out))
+
+	if *update {
+		require.NoError(t, os.WriteFile(RGBDS_GOLDEN, out.Bytes(), 0o644))
+	}
+
+	golden, err := os.ReadFile(RGBDS_GOLDEN)
+	require.NoError(t, err)
+	assert.Equal(t, string(golden), out.String())
+}
+
+func Test_WriteRGBDS_Labels_Defined(t *testing.T) {
+	d, err := Disassemble(symbolsROM(), WithSymbols(map[Location]string{
+		{Bank: 0, Addr: 0x0001}: "Pointer",
+		{Bank: 0, Addr: 0x0101}: "Value",
+	}))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, d.WriteRGBDS(&out))
+
+	defined := map[string]bool{}
+	label := regexp.MustCompile(`^(\w+):$|^DEF (\w+) EQU `)
+	operand := regexp.MustCompile(`^\t(?:jp|jr|call) +(?:\w+, )?([A-Za-z_]\w*)$`)
+
+	var used []string
+
+	for line := range strings.Lines(out.String()) {
+		line = strings.TrimSuffix(line, "\n")
+
+		if m := label.FindStringSubmatch(line); m != nil {
+			defined[m[1]+m[2]] = true
+		}
+
+		if m := operand.FindStringSubmatch(line); m != nil {
+			used = append(used, m[1])
+		}
+	}
+
+	require.NotEmpty(t, used)
+
+	for _, name := range used {
+		assert.True(t, defined[name], "%s is used but never defined", name)
+	}
+
+	assert.NotContains(t, out.String(), "Value:")
+	assert.NotContains(t, out.String(), "Pointer:")
+}
+
+func Test_WriteRGBDS_Partial_Bank(t *testing.T) {
+	d, err := Disassemble(symbolsROM()[:0x200])
+	require.NoError(t, err)
+
+	assert.Error(t, d.WriteRGBDS(io.Discard))
+}
+
+// Test_WriteRGBDS_Round_Trip rebuilds the disassembly with rgbasm and rgblink, it is skipped when they aren't installed
+func Test_WriteRGBDS_Round_Trip(t *testing.T) {
+	for _, tool := range []string{"rgbasm", "rgblink"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	acid2, err := os.ReadFile("../../testdata/roms/dmg-acid2.gb")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		rom     []uint8
+		symbols map[Location]string
+	}{
+		{
+			name: "symbols",
+			rom:  symbolsROM(),
+			symbols: map[Location]string{
+				{Bank: 0, Addr: 0x0100}: "Start",
+				{Bank: 0, Addr: 0x0001}: "Pointer",
+				{Bank: 0, Addr: 0x0101}: "Value",
+			},
+		},
+		{
+			name: "dmg-acid2",
+			rom:  acid2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			asmPath := filepath.Join(dir, "rom.asm")
+			objPath := filepath.Join(dir, "rom.o")
+			romPath := filepath.Join(dir, "rom.gb")
+
+			d, err := Disassemble(tt.rom, WithSymbols(tt.symbols))
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			require.NoError(t, d.WriteRGBDS(&out))
+			require.NoError(t, os.WriteFile(asmPath, out.Bytes(), 0o644))
+
+			for _, cmd := range []*exec.Cmd{
+				exec.Command("rgbasm", "-o", objPath, asmPath),
+				exec.Command("rgblink", "-o", romPath, objPath),
+			} {
+				output, err := cmd.CombinedOutput()
+				require.NoError(t, err, "%s: %s", cmd, output)
+			}
+
+			rebuilt, err := os.ReadFile(romPath)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(tt.rom, rebuilt), "rebuilt ROM differs, %d bytes instead of %d", len(rebuilt), len(tt.rom))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+; Disassembled by gbgo
+
+DEF HEADER_CGB_FLAG EQU $00
+DEF HEADER_NEW_LICENSEE_CODE EQU $0000
+DEF HEADER_SGB_FLAG EQU $00
+DEF HEADER_CARTRIDGE_TYPE EQU $00
+DEF HEADER_ROM_SIZE EQU $00
+DEF HEADER_RAM_SIZE EQU $00
+DEF HEADER_DESTINATION_CODE EQU $00
+DEF HEADER_OLD_LICENSEE_CODE EQU $00
+DEF HEADER_ROM_VERSION EQU $00
+DEF HEADER_CHECKSUM EQU $00
+DEF HEADER_GLOBAL_CHECKSUM EQU $0000
+
+SECTION "ROM Bank $000", ROM0[$0000]
+
+L000_0000:
+	ld   hl, $1234
+DEF Pointer EQU L000_0000+1
+	ret
+	db $C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	db $C9,$C9,$C9,$C9,$C9,$C9,$C9
+	ret
+	ds 159, $C9
+Start:
+	ld   a, $05
+DEF Value EQU Start+1
+	jr   L000_0150
+HeaderLogo:
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00,$00
+HeaderTitle:
+	db $00,$00,$00,$00,$00,$00,$00,$00
+	db $00,$00,$00,$00,$00,$00,$00
+	db HEADER_CGB_FLAG
+	db HIGH(HEADER_NEW_LICENSEE_CODE), LOW(HEADER_NEW_LICENSEE_CODE)
+	db HEADER_SGB_FLAG
+	db HEADER_CARTRIDGE_TYPE
+	db HEADER_ROM_SIZE
+	db HEADER_RAM_SIZE
+	db HEADER_DESTINATION_CODE
+	db HEADER_OLD_LICENSEE_CODE
+	db HEADER_ROM_VERSION
+	db HEADER_CHECKSUM
+	db HIGH(HEADER_GLOBAL_CHECKSUM), LOW(HEADER_GLOBAL_CHECKSUM)
+L000_0150:
+	call Pointer
+	jp   Value
+	ds 16042, $00
+
+SECTION "ROM Bank $001", ROMX[$4000], BANK[$001]
+
+	ds 16384, $00
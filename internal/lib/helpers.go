@@ -46,12 +46,14 @@ func (f *FIFO[T]) Pop() (T, bool) {
 	return e, true
 }
 
+// Peek returns the element at idx, counted from the head
 func (f *FIFO[T]) Peek(idx int) T {
-	return f.Elements[idx]
+	return f.Elements[(f.Head+idx)%len(f.Elements)]
 }
 
+// Replace overwrites the element at idx, counted from the head
 func (f *FIFO[T]) Replace(idx int, e T) {
-	f.Elements[idx] = e
+	f.Elements[(f.Head+idx)%len(f.Elements)] = e
 }
 
 func (f *FIFO[T]) Clear() {
@@ -4,10 +4,17 @@ import (
 	"fmt"
 )
 
-var DebugEnabled bool
+// Logger prints debug logs when enabled, a nil Logger prints nothing
+type Logger struct {
+	debug bool
+}
+
+func New(debug bool) *Logger {
+	return &Logger{debug: debug}
+}
 
-func Debug(format string, args ...any) {
-	if DebugEnabled {
+func (l *Logger) Debug(format string, args ...any) {
+	if l != nil && l.debug {
 		fmt.Printf(format+"\n", args...)
 	}
 }
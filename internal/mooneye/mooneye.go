@@ -0,0 +1,272 @@
+package mooneye
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cterence/gbgo/internal/console"
+	"github.com/cterence/gbgo/internal/console/components/timer"
+)
+
+const (
+	// Tests report their result within a few emulated seconds
+	MAX_CYCLES = 20 * timer.CPU_FREQ
+
+	FAILURE_SIGNATURE = 0x42
+)
+
+// Directories holding tests that can't report their result through registers
+var skippedDirs = []string{"manual-only", "utils", "madness"}
+
+// Model suffix of a test name, e.g. boot_regs-dmgABC or di_timing-GS
+var modelSuffix = regexp.MustCompile(`-(dmg0|dmgABC\w*|mgb|sgb2?|cgb\w*|agb|ags|[GSCA]+)$`)
+
+type Status uint8
+
+const (
+	PASS Status = iota
+	FAIL
+	ERROR // The test didn't reach its breakpoint or left an unknown signature
+)
+
+func (s Status) String() string {
+	switch s {
+	case PASS:
+		return "PASS"
+	case FAIL:
+		return "FAIL"
+	default:
+		return "ERROR"
+	}
+}
+
+type Result struct {
+	ROM       string
+	Status    Status
+	Registers console.Registers
+	Duration  time.Duration
+	Err       error
+}
+
+// Run runs a mooneye test ROM until its LD B,B breakpoint and checks the register signature.
+// A test that crashes the emulator is an ERROR.
+func Run(romPath string) (result Result) {
+	result = Result{ROM: romPath, Status: ERROR}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result.Status = ERROR
+			result.Err = fmt.Errorf("emulator panicked: %v", r)
+		}
+	}()
+
+	romBytes, err := os.ReadFile(romPath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	gb, err := console.NewHeadless(romBytes)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer gb.Close()
+
+	start := time.Now()
+	reached := gb.RunUntil(MAX_CYCLES, gb.AtBreakpoint)
+	result.Duration = time.Since(start)
+	result.Registers = gb.Registers()
+
+	if !reached {
+		result.Err = fmt.Errorf("breakpoint not reached after %d cycles", MAX_CYCLES)
+		return result
+	}
+
+	r := result.Registers
+
+	switch {
+	case r.B == 3 && r.C == 5 && r.D == 8 && r.E == 13 && r.H == 21 && r.L == 34:
+		result.Status = PASS
+	case r.B == FAILURE_SIGNATURE && r.C == FAILURE_SIGNATURE && r.D == FAILURE_SIGNATURE &&
+		r.E == FAILURE_SIGNATURE && r.H == FAILURE_SIGNATURE && r.L == FAILURE_SIGNATURE:
+		result.Status = FAIL
+	default:
+		result.Err = fmt.Errorf("unknown register signature B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X", r.B, r.C, r.D, r.E, r.H, r.L)
+	}
+
+	return result
+}
+
+// ParseStatus parses a status as printed by Status.String
+func ParseStatus(status string) (Status, error) {
+	for _, s := range []Status{PASS, FAIL, ERROR} {
+		if s.String() == status {
+			return s, nil
+		}
+	}
+
+	return ERROR, fmt.Errorf("unknown mooneye status: %s", status)
+}
+
+// ReadKnownFailures reads the expected status of the tests that don't pass, one "<status> <path>" per line
+// with paths relative to the build directory. Empty lines and lines starting with # are ignored.
+func ReadKnownFailures(path string) (map[string]Status, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known failures: %w", err)
+	}
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("failed to close known failures: %v\n", err)
+		}
+	}()
+
+	known := map[string]Status{}
+	scanner := bufio.NewScanner(f)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid known failure at line %d: %q", n, line)
+		}
+
+		status, err := ParseStatus(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid known failure at line %d: %w", n, err)
+		}
+
+		known[fields[1]] = status
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known failures: %w", err)
+	}
+
+	return known, nil
+}
+
+// Find lists the test ROMs of dir that run on a DMG
+func Find(dir string) ([]string, error) {
+	var roms []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			for _, skipped := range skippedDirs {
+				if d.Name() == skipped {
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		if filepath.Ext(path) == ".gb" && runsOnDMG(path) {
+			roms = append(roms, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mooneye tests: %w", err)
+	}
+
+	return roms, nil
+}
+
+// WriteMatrix prints the result of every test, grouped by directory, followed by pass counts
+func WriteMatrix(w io.Writer, dir string, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	type suite struct {
+		name          string
+		passed, total int
+		totalDuration time.Duration
+	}
+
+	var suites []*suite
+
+	bySuite := map[string]*suite{}
+	passed := 0
+	totalDuration := time.Duration(0)
+
+	fmt.Fprintln(tw, "SUITE\tTEST\tRESULT\tTIME\tDETAILS")
+
+	for _, r := range results {
+		rel, err := filepath.Rel(dir, r.ROM)
+		if err != nil {
+			rel = r.ROM
+		}
+
+		name := filepath.Dir(rel)
+
+		s, ok := bySuite[name]
+		if !ok {
+			s = &suite{name: name}
+			bySuite[name] = s
+			suites = append(suites, s)
+		}
+
+		s.total++
+		s.totalDuration += r.Duration
+		totalDuration += r.Duration
+
+		if r.Status == PASS {
+			s.passed++
+			passed++
+		}
+
+		details := ""
+		if r.Err != nil {
+			details = r.Err.Error()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, strings.TrimSuffix(filepath.Base(rel), ".gb"), r.Status, r.Duration.Round(time.Millisecond), details)
+	}
+
+	fmt.Fprintln(tw, "\t\t\t\t")
+	fmt.Fprintln(tw, "SUITE\tPASSED\tTOTAL\tTIME\t")
+
+	for _, s := range suites {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t\n", s.name, s.passed, s.total, s.totalDuration.Round(time.Millisecond))
+	}
+
+	fmt.Fprintf(tw, "total\t%d\t%d\t%s\t\n", passed, len(results), totalDuration.Round(time.Millisecond))
+
+	return tw.Flush()
+}
+
+// runsOnDMG checks the model suffix of a test name, tests without one run on every model
+func runsOnDMG(path string) bool {
+	match := modelSuffix.FindStringSubmatch(strings.TrimSuffix(filepath.Base(path), ".gb"))
+	if match == nil {
+		return true
+	}
+
+	model := match[1]
+
+	// Uppercase suffixes are model groups, G being DMG and MGB
+	if strings.ToUpper(model) == model {
+		return strings.Contains(model, "G")
+	}
+
+	return strings.HasPrefix(model, "dmgABC")
+}
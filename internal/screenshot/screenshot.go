@@ -0,0 +1,92 @@
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"github.com/cterence/gbgo/internal/console/components/ppu"
+)
+
+type Frame = [ppu.WIDTH][ppu.HEIGHT]uint8
+
+// Palette maps frame shades to the colors of a reference image
+type Palette [4]color.RGBA
+
+// Palette of the UI and of the dmg-acid2 reference image
+var GRAYSCALE = Palette{
+	{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+	{R: 0xAA, G: 0xAA, B: 0xAA, A: 0xFF},
+	{R: 0x55, G: 0x55, B: 0x55, A: 0xFF},
+	{R: 0x00, G: 0x00, B: 0x00, A: 0xFF},
+}
+
+var mismatchColor = color.RGBA{R: 0xFF, A: 0xFF}
+
+func Image(frame Frame, palette Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ppu.WIDTH, ppu.HEIGHT))
+
+	for y := range ppu.HEIGHT {
+		for x := range ppu.WIDTH {
+			img.SetRGBA(x, y, palette[frame[x][y]])
+		}
+	}
+
+	return img
+}
+
+// Compare counts the pixels of frame that differ from reference, and returns a diff image
+// where they are red while matching pixels are dimmed
+func Compare(frame Frame, reference image.Image, palette Palette) (int, *image.RGBA, error) {
+	bounds := reference.Bounds()
+	if bounds.Dx() != ppu.WIDTH || bounds.Dy() != ppu.HEIGHT {
+		return 0, nil, fmt.Errorf("reference is %dx%d instead of %dx%d", bounds.Dx(), bounds.Dy(), ppu.WIDTH, ppu.HEIGHT)
+	}
+
+	diff := image.NewRGBA(image.Rect(0, 0, ppu.WIDTH, ppu.HEIGHT))
+	mismatches := 0
+
+	for y := range ppu.HEIGHT {
+		for x := range ppu.WIDTH {
+			want := color.RGBAModel.Convert(reference.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.RGBA)
+			got := palette[frame[x][y]]
+
+			if want != got {
+				mismatches++
+
+				diff.SetRGBA(x, y, mismatchColor)
+
+				continue
+			}
+
+			diff.SetRGBA(x, y, color.RGBA{R: 0x80 + want.R/2, G: 0x80 + want.G/2, B: 0x80 + want.B/2, A: 0xFF})
+		}
+	}
+
+	return mismatches, diff, nil
+}
+
+func ReadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PNG: %w", err)
+	}
+
+	img, err := png.Decode(f)
+
+	return img, errors.Join(err, f.Close())
+}
+
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create PNG: %w", err)
+	}
+
+	err = png.Encode(f, img)
+
+	return errors.Join(err, f.Close())
+}
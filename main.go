@@ -8,11 +8,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/pprof"
+	"syscall"
 
 	"github.com/cterence/gbgo/internal/console"
+	"github.com/cterence/gbgo/internal/console/components/cdl"
+	"github.com/cterence/gbgo/internal/console/components/debugger"
+	"github.com/cterence/gbgo/internal/disassembler"
 	"github.com/cterence/gbgo/internal/log"
+	"github.com/cterence/gbgo/internal/mooneye"
 	"github.com/urfave/cli/v3"
 )
 
@@ -22,10 +28,21 @@ const (
 
 func main() {
 	var (
-		opts      []console.Option
-		pprofChan chan struct{}
+		opts        []console.Option
+		traceFilter *debugger.Filter
+		pprofChan   chan struct{}
+		logger      *log.Logger
 	)
 
+	// Trace filter flags all contribute to the same filter
+	filter := func() *debugger.Filter {
+		if traceFilter == nil {
+			traceFilter = &debugger.Filter{}
+		}
+
+		return traceFilter
+	}
+
 	cmd := &cli.Command{
 		Name:  "gbgo",
 		Usage: "gameboy emulator",
@@ -69,7 +86,7 @@ func main() {
 				Aliases: []string{"d"},
 				Usage:   "print emulator debug logs",
 				Action: func(_ context.Context, _ *cli.Command, b bool) error {
-					log.DebugEnabled = b
+					logger = log.New(b)
 
 					opts = append(opts, console.WithDebug())
 
@@ -77,6 +94,148 @@ func main() {
 				},
 			},
 
+			&cli.StringFlag{
+				Name:    "trace-format",
+				Aliases: []string{"tf"},
+				Usage:   "trace CPU instructions to stdout using the given format (default, doctor)",
+				Action: func(_ context.Context, _ *cli.Command, format string) error {
+					traceFormat, err := debugger.ParseFormat(format)
+					if err != nil {
+						return err
+					}
+
+					opts = append(opts, console.WithTrace(traceFormat))
+
+					return nil
+				},
+			},
+
+			&cli.StringFlag{
+				Name:      "trace-file",
+				Aliases:   []string{"tfile"},
+				Usage:     "write CPU traces to file instead of stdout",
+				TakesFile: true,
+				Action: func(_ context.Context, _ *cli.Command, path string) error {
+					opts = append(opts, console.WithTraceFile(path))
+
+					return nil
+				},
+			},
+
+			&cli.BoolFlag{
+				Name:  "trace-drop",
+				Usage: "drop CPU traces instead of slowing down emulation when the writer can't keep up",
+				Action: func(_ context.Context, _ *cli.Command, b bool) error {
+					opts = append(opts, console.WithTraceDrop())
+
+					return nil
+				},
+			},
+
+			&cli.StringSliceFlag{
+				Name:  "trace-pc",
+				Usage: "only trace instructions in the given PC ranges, optionally in a ROM bank (e.g. 0150-01ff, 02:4000-4fff)",
+				Action: func(_ context.Context, _ *cli.Command, ranges []string) error {
+					for _, r := range ranges {
+						pcRange, err := debugger.ParseRange(r)
+						if err != nil {
+							return err
+						}
+
+						filter().PCRanges = append(filter().PCRanges, pcRange)
+					}
+
+					return nil
+				},
+			},
+
+			&cli.UintSliceFlag{
+				Name:  "trace-bank",
+				Usage: "only trace instructions running from the given ROM banks",
+				Action: func(_ context.Context, _ *cli.Command, banks []uint) error {
+					for _, b := range banks {
+						filter().Banks = append(filter().Banks, uint16(b))
+					}
+
+					return nil
+				},
+			},
+
+			&cli.BoolFlag{
+				Name:  "trace-interrupts",
+				Usage: "trace interrupts taken instead of instructions",
+				Action: func(_ context.Context, _ *cli.Command, b bool) error {
+					filter().Interrupts = b
+
+					return nil
+				},
+			},
+
+			&cli.BoolFlag{
+				Name:  "trace-io",
+				Usage: "trace IO register writes instead of instructions",
+				Action: func(_ context.Context, _ *cli.Command, b bool) error {
+					filter().IOWrites = b
+
+					return nil
+				},
+			},
+
+			&cli.Uint64Flag{
+				Name:  "trace-frames",
+				Usage: "stop tracing after the given number of frames",
+				Action: func(_ context.Context, _ *cli.Command, frames uint64) error {
+					filter().Frames = frames
+
+					return nil
+				},
+			},
+
+			&cli.StringSliceFlag{
+				Name:  "trace-start",
+				Usage: "start tracing when PC reaches one of the given addresses",
+				Action: func(_ context.Context, _ *cli.Command, addrs []string) error {
+					for _, a := range addrs {
+						addr, err := debugger.ParseAddress(a)
+						if err != nil {
+							return err
+						}
+
+						filter().StartAddrs = append(filter().StartAddrs, addr)
+					}
+
+					return nil
+				},
+			},
+
+			&cli.StringSliceFlag{
+				Name:  "trace-stop",
+				Usage: "stop tracing when PC reaches one of the given addresses",
+				Action: func(_ context.Context, _ *cli.Command, addrs []string) error {
+					for _, a := range addrs {
+						addr, err := debugger.ParseAddress(a)
+						if err != nil {
+							return err
+						}
+
+						filter().StopAddrs = append(filter().StopAddrs, addr)
+					}
+
+					return nil
+				},
+			},
+
+			&cli.StringFlag{
+				Name:      "cdl",
+				Usage:     "record code/data coverage to the given CDL file, JSON and HTML reports are written next to it",
+				TakesFile: true,
+				Action: func(_ context.Context, _ *cli.Command, path string) error {
+					opts = append(opts, console.WithCDL(path))
+
+					return nil
+				},
+			},
+
 			&cli.StringFlag{
 				Name:      "boot",
 				Aliases:   []string{"b"},
@@ -130,6 +289,17 @@ func main() {
 					return nil
 				},
 			},
+
+			&cli.BoolFlag{
+				Name:    "fast",
+				Aliases: []string{"f"},
+				Usage:   "tick components once per instruction instead of per memory access, faster but less accurate",
+				Action: func(_ context.Context, _ *cli.Command, b bool) error {
+					opts = append(opts, console.WithFastPath())
+
+					return nil
+				},
+			},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			romPath := cmd.Args().First()
@@ -164,7 +334,7 @@ func main() {
 							return fmt.Errorf("failed to read file %s bytes: %w", f.Name, err)
 						}
 
-						log.Debug("[main] read file %s in archive", f.Name)
+						logger.Debug("[main] read file %s in archive", f.Name)
 
 						break // Only read one .gb file
 					}
@@ -176,6 +346,10 @@ func main() {
 				return err
 			}
 
+			if traceFilter != nil {
+				opts = append(opts, console.WithTraceFilter(*traceFilter))
+			}
+
 			stateDir := filepath.Join(homeDir, ".local", "share", "gbgo")
 
 			err = os.MkdirAll(stateDir, 0755)
@@ -183,13 +357,32 @@ func main() {
 				return err
 			}
 
-			return console.Run(romBytes, romPath, stateDir, opts...)
+			return console.Run(ctx, romBytes, romPath, stateDir, opts...)
 		},
 		Commands: []*cli.Command{
 			{
 				Name:    "disassemble",
 				Aliases: []string{"d"},
 				Usage:   "disassemble a rom",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "symbols",
+						Aliases:   []string{"s"},
+						Usage:     "path to RGBDS symbol file, symbols are used as labels and code entrypoints",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:      "cdl",
+						Usage:     "path to CDL file recorded with --cdl, used to separate code from data",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "output format (plain, rgbds)",
+						Value:   "plain",
+					},
+				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					romPath := cmd.Args().First()
 
@@ -203,13 +396,116 @@ func main() {
 						return err
 					}
 
-					return console.Disassemble(romBytes)
+					var disassemblerOpts []disassembler.Option
+
+					if symbolsPath := cmd.String("symbols"); symbolsPath != "" {
+						f, err := os.Open(symbolsPath)
+						if err != nil {
+							return fmt.Errorf("failed to open symbol file: %w", err)
+						}
+
+						defer func() {
+							if err := f.Close(); err != nil {
+								fmt.Printf("failed to close symbol file: %v\n", err)
+							}
+						}()
+
+						symbols, err := disassembler.ParseSymbols(f)
+						if err != nil {
+							return err
+						}
+
+						disassemblerOpts = append(disassemblerOpts, disassembler.WithSymbols(symbols))
+					}
+
+					if cdlPath := cmd.String("cdl"); cdlPath != "" {
+						f, err := os.Open(cdlPath)
+						if err != nil {
+							return fmt.Errorf("failed to open CDL file: %w", err)
+						}
+
+						defer func() {
+							if err := f.Close(); err != nil {
+								fmt.Printf("failed to close CDL file: %v\n", err)
+							}
+						}()
+
+						c, err := cdl.Read(f)
+						if err != nil {
+							return err
+						}
+
+						disassemblerOpts = append(disassemblerOpts, disassembler.WithCDL(c))
+					}
+
+					d, err := disassembler.Disassemble(romBytes, disassemblerOpts...)
+					if err != nil {
+						return err
+					}
+
+					switch format := cmd.String("format"); format {
+					case "plain":
+						return d.Write(os.Stdout)
+					case "rgbds":
+						return d.WriteRGBDS(os.Stdout)
+					default:
+						return fmt.Errorf("unsupported disassembly format: %s", format)
+					}
+				},
+			},
+			{
+				Name:  "test",
+				Usage: "run test rom suites",
+				Commands: []*cli.Command{
+					{
+						Name:  "mooneye",
+						Usage: "run the mooneye test suite roms found in a directory",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							dir := cmd.Args().First()
+
+							if dir == "" {
+								fmt.Printf("error: no test directory given\n\n")
+								return cli.ShowSubcommandHelp(cmd)
+							}
+
+							roms, err := mooneye.Find(dir)
+							if err != nil {
+								return err
+							}
+
+							results := make([]mooneye.Result, 0, len(roms))
+							failed := 0
+
+							for _, rom := range roms {
+								if ctx.Err() != nil {
+									break
+								}
+
+								result := mooneye.Run(rom)
+								if result.Status != mooneye.PASS {
+									failed++
+								}
+
+								results = append(results, result)
+							}
+
+							if err := mooneye.WriteMatrix(os.Stdout, dir, results); err != nil {
+								return err
+							}
+
+							if failed > 0 {
+								return fmt.Errorf("%d/%d mooneye tests failed", failed, len(results))
+							}
+
+							return nil
+						},
+					},
 				},
 			},
 		},
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	err := cmd.Run(ctx, os.Args)
 
 	cancel()
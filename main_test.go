@@ -1,53 +1,614 @@
 package main
 
 import (
-	"io"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
-	"time"
 
+	"github.com/cterence/gbgo/internal/blargg"
+	"github.com/cterence/gbgo/internal/console"
+	"github.com/cterence/gbgo/internal/console/components/cdl"
+	"github.com/cterence/gbgo/internal/console/components/debugger"
+	"github.com/cterence/gbgo/internal/console/components/ppu"
+	"github.com/cterence/gbgo/internal/mooneye"
+	"github.com/cterence/gbgo/internal/screenshot"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestMain(m *testing.M) {
-	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
-		main()
+func Test_Blargg_CPU_Serial(t *testing.T) {
+	expected := "cpu_instrs\n\n01:ok  02:ok  03:ok  04:ok  05:ok  06:ok  07:ok  08:ok  09:ok  10:ok  11:ok  \n\nPassed all tests"
+
+	romBytes, err := os.ReadFile("./sub/gb-test-roms/cpu_instrs/cpu_instrs.gb")
+	require.NoError(t, err)
+
+	var serial strings.Builder
+
+	gb, err := console.NewHeadless(romBytes, console.WithSerialWriter(&serial))
+	require.NoError(t, err)
+
+	defer gb.Close()
+
+	done := gb.RunUntil(blargg.MAX_CYCLES, func() bool {
+		return serial.Len() >= len(expected)
+	})
+
+	require.True(t, done, "serial output stopped after %q", serial.String())
+	assert.Equal(t, expected, serial.String())
+}
+
+type blarggReport uint8
+
+const (
+	BLARGG_SERIAL blarggReport = iota
+	BLARGG_MEMORY
+	BLARGG_SCREEN
+)
+
+func Test_Blargg(t *testing.T) {
+	suites := []struct {
+		name   string
+		roms   string
+		report blarggReport
+		// ROMs expected to fail, they must be removed once they pass
+		failing []string
+	}{
+		{name: "cpu_instrs", roms: "cpu_instrs/cpu_instrs.gb", report: BLARGG_SCREEN},
+		{name: "cpu_instrs-individual", roms: "cpu_instrs/individual/*.gb", report: BLARGG_SERIAL},
+		{name: "instr_timing", roms: "instr_timing/instr_timing.gb", report: BLARGG_SCREEN},
+		{name: "mem_timing", roms: "mem_timing/individual/*.gb", report: BLARGG_SERIAL},
+		{name: "mem_timing-2", roms: "mem_timing-2/rom_singles/*.gb", report: BLARGG_MEMORY},
+		{name: "halt_bug", roms: "halt_bug.gb", report: BLARGG_SCREEN},
+		{name: "oam_bug", roms: "oam_bug/oam_bug.gb", report: BLARGG_SCREEN},
+		{
+			name:   "oam_bug-singles",
+			roms:   "oam_bug/rom_singles/*.gb",
+			report: BLARGG_MEMORY,
+			// Its text is longer than the cartridge RAM and overwrites the code it runs from WRAM, oam_bug.gb covers it
+			failing: []string{"7-timing_effect"},
+		},
+		{
+			name:   "dmg_sound",
+			roms:   "dmg_sound/rom_singles/*.gb",
+			report: BLARGG_MEMORY,
+			// The APU channels aren't emulated
+			failing: []string{
+				"01-registers", "02-len ctr", "03-trigger", "04-sweep", "05-sweep details", "06-overflow on trigger",
+				"07-len sweep period sync", "08-len ctr during power", "09-wave read while on", "10-wave trigger while on",
+				"11-regs after power", "12-wave write while on",
+			},
+		},
+		{
+			name:   "interrupt_time",
+			roms:   "interrupt_time/interrupt_time.gb",
+			report: BLARGG_SCREEN,
+			// It switches the CPU to double speed, which only a CGB has, a DMG fails it too
+			failing: []string{"interrupt_time"},
+		},
 	}
 
-	os.Exit(m.Run())
+	for _, suite := range suites {
+		t.Run(suite.name, func(t *testing.T) {
+			roms, err := filepath.Glob(filepath.Join("./sub/gb-test-roms", suite.roms))
+			require.NoError(t, err)
+
+			if len(roms) == 0 {
+				t.Skipf("no rom matching %s", suite.roms)
+			}
+
+			for _, rom := range roms {
+				name := strings.TrimSuffix(filepath.Base(rom), ".gb")
+
+				t.Run(name, func(t *testing.T) {
+					romBytes, err := os.ReadFile(rom)
+					require.NoError(t, err)
+
+					run := blargg.RunSerial
+
+					switch suite.report {
+					case BLARGG_MEMORY:
+						run = blargg.RunMemory
+					case BLARGG_SCREEN:
+						run = blargg.RunScreen
+					}
+
+					result, err := run(romBytes)
+
+					// A failing ROM may also never report its result
+					if slices.Contains(suite.failing, name) {
+						assert.False(t, err == nil && result.Passed, "%s passes, remove it from the failing ROMs", name)
+						return
+					}
+
+					require.NoError(t, err, result.Output)
+					assert.True(t, result.Passed, result.Output)
+
+					// Screen reports are also compared with the screen of a DMG, references come from
+					// https://github.com/c-sp/gameboy-test-roms (MIT)
+					if suite.report == BLARGG_SCREEN {
+						assertFrame(t, result.Frame, filepath.Join("./testdata/screenshots", "blargg-"+name+".png"), screenshot.GRAYSCALE, true)
+					}
+				})
+			}
+		})
+	}
 }
 
-func Test_Blargg_CPU_Serial(t *testing.T) {
-	duration := 5 * time.Second
-	timeout := time.After(duration)
-	done := make(chan bool)
-	expected := []byte("cpu_instrs\n\n01:ok  02:ok  03:ok  04:ok  05:ok  06:ok  07:ok  08:ok  09:ok  10:ok  11:ok  \n\nPassed all tests")
-	cmd := exec.Command(os.Args[0], "./sub/gb-test-roms/cpu_instrs/cpu_instrs.gb", "--hl", "--ps", "--ns")
-
-	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
-
-	r, w := io.Pipe()
-	cmd.Stdout = w
-
-	assert.NoError(t, cmd.Start())
-
-	go func() {
-		buf := make([]uint8, 1)
-		for i, want := range expected {
-			n, err := r.Read(buf)
-			assert.NoError(t, err)
-			assert.Equal(t, 1, n)
-			assert.Equal(t, string(want), string(buf[0]), "wrong byte received at %d", i)
+func Test_Doctor_CPU_Instrs(t *testing.T) {
+	for i := 1; i <= 11; i++ {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			truth := readDoctorTruthLog(t, filepath.Join("./sub/gameboy-doctor/truth/zipped/cpu_instrs", strconv.Itoa(i)+".zip"))
+
+			roms, err := filepath.Glob(fmt.Sprintf("./sub/gb-test-roms/cpu_instrs/individual/%02d-*.gb", i))
+			require.NoError(t, err)
+			require.Len(t, roms, 1)
+
+			romBytes, err := os.ReadFile(roms[0])
+			require.NoError(t, err)
+
+			trace := &doctorTrace{truth: truth}
+
+			gb, err := console.NewHeadless(romBytes, console.WithTrace(debugger.FORMAT_DOCTOR), console.WithTraceWriter(trace))
+			require.NoError(t, err)
+
+			gb.RunUntil(blargg.MAX_CYCLES, trace.finished.Load)
+			// Flushes the queued traces, the trace isn't written to afterwards
+			gb.Close()
+
+			if trace.mismatch != "" {
+				t.Fatal(trace.mismatch)
+			}
+
+			require.Equal(t, len(truth), trace.line, "trace ended early")
+		})
+	}
+}
+
+// doctorTrace compares the lines written by the debugger with a gameboy-doctor truth log
+type doctorTrace struct {
+	truth    []string
+	pending  []uint8
+	line     int
+	mismatch string
+	// Set once every line has been compared or one differs
+	finished atomic.Bool
+}
+
+func (d *doctorTrace) Write(p []uint8) (int, error) {
+	d.pending = append(d.pending, p...)
+
+	for !d.finished.Load() {
+		end := bytes.IndexByte(d.pending, '\n')
+		if end < 0 {
+			break
+		}
+
+		got, want := string(d.pending[:end]), d.truth[d.line]
+		d.pending = d.pending[end+1:]
+
+		if got != want {
+			d.mismatch = fmt.Sprintf("trace mismatch at line %d\nwant: %s\ngot:  %s", d.line+1, want, got)
+			d.finished.Store(true)
+
+			break
+		}
+
+		d.line++
+		d.finished.Store(d.line == len(d.truth))
+	}
+
+	return len(p), nil
+}
+
+const MOONEYE_KNOWN_FAILURES = "./testdata/mooneye-known-failures.txt"
+
+var update = flag.Bool("update", false, "write screenshot references from the current output")
+
+func Test_Screenshots(t *testing.T) {
+	tests := []struct {
+		name   string
+		rom    string
+		frames uint64
+		// Checked in with its reference, which must not be skipped
+		required bool
+	}{
+		// https://github.com/mattcurrie/dmg-acid2 (MIT), its reference-dmg.png uses the grayscale palette
+		{name: "dmg-acid2", rom: "./testdata/roms/dmg-acid2.gb", frames: 60, required: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertScreenshot(t, tt.rom, filepath.Join("./testdata/screenshots", tt.name+".png"), tt.frames, screenshot.GRAYSCALE, tt.required)
+		})
+	}
+}
+
+// objectTile has a different pattern on each row, so that a shifted or flipped object shows
+func objectTile() []uint8 {
+	tile := make([]uint8, 16)
+
+	for row := range 8 {
+		tile[row*2] = 0xB1>>row | 0xB1<<(8-row)
+		tile[row*2+1] = 0x63
+	}
+
+	return tile
+}
+
+type frameObject struct {
+	y, x, attrs uint8
+}
+
+// objectsROM turns the LCD off, loads objectTile as tile 1 and the objects in OAM, then turns the LCD on with objects
+func objectsROM(objects []frameObject) []uint8 {
+	const (
+		codeAddr = 0x0150
+		tileAddr = 0x0200
+		oamAddr  = 0x0300
+	)
+
+	rom := make([]uint8, 2*0x4000)
+
+	copy(rom[0x100:], []uint8{0x00, 0xC3, codeAddr & 0xFF, codeAddr >> 8}) // nop, jp codeAddr
+
+	copy(rom[codeAddr:], []uint8{
+		0xF3,       // di
+		0xAF,       // xor a
+		0xE0, 0x40, // ldh [LCDC], a
+		0x21, 0x10, 0x80, // ld hl, $8010
+		0x11, tileAddr & 0xFF, tileAddr >> 8, // ld de, tileAddr
+		0x0E, 16, // ld c, 16
+		0x1A, 0x13, 0x22, 0x0D, 0x20, 0xFA, // ld a, [de]; inc de; ld [hl+], a; dec c; jr nz, -6
+		0x21, 0x00, 0xFE, // ld hl, $FE00
+		0x11, oamAddr & 0xFF, oamAddr >> 8, // ld de, oamAddr
+		0x0E, 160, // ld c, 160
+		0x1A, 0x13, 0x22, 0x0D, 0x20, 0xFA, // ld a, [de]; inc de; ld [hl+], a; dec c; jr nz, -6
+		0x3E, 0xE4, // ld a, $E4
+		0xE0, 0x47, // ldh [BGP], a
+		0xE0, 0x48, // ldh [OBP0], a
+		0x3E, 0x1B, // ld a, $1B
+		0xE0, 0x49, // ldh [OBP1], a
+		0x3E, 0x93, // ld a, $93
+		0xE0, 0x40, // ldh [LCDC], a
+		0x18, 0xFE, // jr -2
+	})
+
+	copy(rom[tileAddr:], objectTile())
+
+	for i, obj := range objects {
+		copy(rom[oamAddr+i*4:], []uint8{obj.y, obj.x, 1, obj.attrs})
+	}
+
+	return rom
+}
+
+// expectObjectsFrame draws objects on a blank background: the first opaque pixel wins, objects being ordered by X and
+// then by OAM index
+func expectObjectsFrame(objects []frameObject) screenshot.Frame {
+	var frame screenshot.Frame
+
+	order := make([]int, len(objects))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int {
+		return int(objects[a].x) - int(objects[b].x)
+	})
+
+	tile := objectTile()
+
+	for y := range ppu.HEIGHT {
+		for x := range ppu.WIDTH {
+			for _, i := range order {
+				obj := objects[i]
+				row, col := y+16-int(obj.y), x+8-int(obj.x)
+
+				if row < 0 || row >= 8 || col < 0 || col >= 8 {
+					continue
+				}
+
+				if obj.attrs&0x20 != 0 {
+					col = 7 - col
+				}
+
+				color := tile[row*2]>>(7-col)&1 | tile[row*2+1]>>(7-col)&1<<1
+				if color == 0 {
+					continue
+				}
+
+				palette := uint8(0xE4)
+				if obj.attrs&0x10 != 0 {
+					palette = 0x1B
+				}
+
+				frame[x][y] = palette >> (color * 2) & 0x3
+
+				break
+			}
+		}
+	}
+
+	return frame
+}
+
+// Test_Objects_Frame renders objects on the left edge and overlapping objects through the whole console
+func Test_Objects_Frame(t *testing.T) {
+	var objects []frameObject
+
+	// Every X on the left edge, one object per line band
+	for x := range uint8(9) {
+		objects = append(objects, frameObject{y: 16 + x*10, x: x})
+	}
+
+	objects = append(objects,
+		// X flipped on the left edge
+		frameObject{y: 16 + 90, x: 4, attrs: 0x20},
+		// Same X, the first in OAM wins
+		frameObject{y: 16 + 100, x: 40, attrs: 0x10},
+		frameObject{y: 16 + 100, x: 40},
+		// Lower X wins, also on the left edge
+		frameObject{y: 16 + 110, x: 6, attrs: 0x10},
+		frameObject{y: 16 + 110, x: 3},
+		frameObject{y: 16 + 120, x: 24, attrs: 0x10},
+		frameObject{y: 16 + 120, x: 20},
+	)
+
+	gb, err := console.NewHeadless(objectsROM(objects))
+	require.NoError(t, err)
+
+	defer gb.Close()
+
+	gb.RunUntil(4*console.CYCLES_PER_FRAME, func() bool { return gb.FrameCount() >= 3 })
+
+	assert.Equal(t, expectObjectsFrame(objects), gb.Frame())
+}
+
+// Headless consoles must not share state, run with -race to catch data races between them
+func Test_Concurrent_Headless(t *testing.T) {
+	const instances = 4
+
+	romBytes, err := os.ReadFile("./testdata/roms/dmg-acid2.gb")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	frames := make([]screenshot.Frame, instances)
+	errs := make([]error, instances)
+
+	for i := range instances {
+		wg.Go(func() {
+			gb, err := console.NewHeadless(romBytes)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			defer gb.Close()
+
+			gb.RunUntil(61*console.CYCLES_PER_FRAME, func() bool {
+				return gb.FrameCount() >= 60
+			})
+
+			frames[i] = gb.Frame()
+		})
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	for _, frame := range frames[1:] {
+		assert.Equal(t, frames[0], frame)
+	}
+}
+
+func Test_Headless_CDL(t *testing.T) {
+	cdlPath := filepath.Join(t.TempDir(), "rom.cdl")
+
+	// jp $4000, then loop in bank 1
+	romBytes := make([]uint8, 2*0x4000)
+	copy(romBytes[0x100:], []uint8{0xC3, 0x00, 0x40})
+	copy(romBytes[0x4000:], []uint8{0x18, 0xFE})
+
+	gb, err := console.NewHeadless(romBytes, console.WithCDL(cdlPath))
+	require.NoError(t, err)
+
+	require.NotPanics(t, func() {
+		gb.RunUntil(console.CYCLES_PER_FRAME, func() bool { return false })
+	})
+
+	gb.Close()
+
+	f, err := os.Open(cdlPath)
+	require.NoError(t, err)
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("failed to close CDL file: %v\n", err)
 		}
+	}()
+
+	c, err := cdl.Read(f)
+	require.NoError(t, err)
+
+	assert.Equal(t, cdl.EXECUTED, c.ROM.Flags[0x4000])
+	assert.Equal(t, cdl.OPERAND, c.ROM.Flags[0x4001])
+}
+
+// Mooneye test roms are built with make in the submodule, the tests that don't pass yet are expected to keep failing
+// so that fixes and regressions both show up
+func Test_Mooneye(t *testing.T) {
+	dir := "./sub/mooneye-test-suite/build"
+
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("no mooneye test roms at %s", dir)
+	}
+
+	roms, err := mooneye.Find(dir)
+	require.NoError(t, err)
+
+	known, err := mooneye.ReadKnownFailures(MOONEYE_KNOWN_FAILURES)
+	require.NoError(t, err)
+
+	results := make([]mooneye.Result, 0, len(roms))
+	ran := map[string]bool{}
+
+	for _, rom := range roms {
+		name, err := filepath.Rel(dir, rom)
+		require.NoError(t, err)
+
+		ran[filepath.ToSlash(name)] = true
+
+		t.Run(strings.TrimSuffix(name, ".gb"), func(t *testing.T) {
+			result := mooneye.Run(rom)
+			results = append(results, result)
+
+			want, ok := known[filepath.ToSlash(name)]
+			if !ok {
+				require.NoError(t, result.Err)
+
+				want = mooneye.PASS
+			}
+
+			assert.Equal(t, want, result.Status, "known failures are listed in %s", MOONEYE_KNOWN_FAILURES)
+		})
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(known)) {
+		assert.True(t, ran[name], "%s is listed in %s but no such rom was built", name, MOONEYE_KNOWN_FAILURES)
+	}
 
-		done <- true
+	var matrix strings.Builder
+
+	require.NoError(t, mooneye.WriteMatrix(&matrix, dir, results))
+	t.Log("\n" + matrix.String())
+}
+
+// assertScreenshot compares the screen of a rom after a number of frames with a reference image,
+// mismatches are written as images to the temporary directory. Run with -update to write the references.
+// A missing rom or reference skips the test unless it is required.
+func assertScreenshot(t *testing.T, romPath, referencePath string, frames uint64, palette screenshot.Palette, required bool) {
+	t.Helper()
+
+	missing := t.Skipf
+	if required {
+		missing = t.Fatalf
+	}
+
+	romBytes, err := os.ReadFile(romPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		missing("no rom at %s", romPath)
+	}
+
+	require.NoError(t, err)
+
+	gb, err := console.NewHeadless(romBytes)
+	require.NoError(t, err)
+
+	defer gb.Close()
+
+	// ROMs that turn the LCD off stop after the cycles of those frames
+	gb.RunUntil((frames+1)*console.CYCLES_PER_FRAME, func() bool {
+		return gb.FrameCount() >= frames || gb.AtBreakpoint()
+	})
+
+	assertFrame(t, gb.Frame(), referencePath, palette, required)
+}
+
+func assertFrame(t *testing.T, frame screenshot.Frame, referencePath string, palette screenshot.Palette, required bool) {
+	t.Helper()
+
+	missing := t.Skipf
+	if required {
+		missing = t.Fatalf
+	}
+
+	if *update {
+		require.NoError(t, os.MkdirAll(filepath.Dir(referencePath), 0755))
+		require.NoError(t, screenshot.WritePNG(referencePath, screenshot.Image(frame, palette)))
+
+		return
+	}
+
+	if _, err := os.Stat(referencePath); errors.Is(err, fs.ErrNotExist) {
+		missing("no reference at %s, run the test with -update to write it", referencePath)
+	}
+
+	reference, err := screenshot.ReadPNG(referencePath)
+	require.NoError(t, err)
+
+	mismatches, diff, err := screenshot.Compare(frame, reference, palette)
+	require.NoError(t, err)
+
+	if mismatches == 0 {
+		return
+	}
+
+	dir := filepath.Join(os.TempDir(), "gbgo-screenshots")
+	name := strings.TrimSuffix(filepath.Base(referencePath), filepath.Ext(referencePath))
+
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, screenshot.WritePNG(filepath.Join(dir, name+"-actual.png"), screenshot.Image(frame, palette)))
+	require.NoError(t, screenshot.WritePNG(filepath.Join(dir, name+"-diff.png"), diff))
+
+	t.Errorf("%d pixels differ from %s, see %s", mismatches, referencePath, filepath.Join(dir, name+"-diff.png"))
+}
+
+func readDoctorTruthLog(t *testing.T, path string) []string {
+	t.Helper()
+
+	r, err := zip.OpenReader(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("no gameboy-doctor truth log at %s", path)
+	}
+
+	require.NoError(t, err)
+
+	defer func() {
+		assert.NoError(t, r.Close())
 	}()
 
-	select {
-	case <-timeout:
-		t.Fatalf("test timed out after %s", duration)
-	case <-done:
-		assert.NoError(t, cmd.Process.Kill())
+	for _, f := range r.File {
+		if filepath.Ext(f.Name) != ".log" {
+			continue
+		}
+
+		rc, err := f.Open()
+		require.NoError(t, err)
+
+		defer func() {
+			assert.NoError(t, rc.Close())
+		}()
+
+		var lines []string
+
+		scanner := bufio.NewScanner(rc)
+		for scanner.Scan() {
+			lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+		}
+
+		require.NoError(t, scanner.Err())
+
+		return lines
 	}
+
+	t.Fatalf("no log file in %s", path)
+
+	return nil
 }